@@ -2,18 +2,65 @@ package alloc
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"math/bits"
 	"sync"
+	"sync/atomic"
 )
 
-// MaxSize is the maximum supported buffer size (64KiB).
+// MaxSize is the maximum buffer size of NewAllocator and the package-level
+// functions (64KiB).
 const MaxSize = 65536
 
+// MaxBitsLimit is the largest maxBits accepted by NewAllocatorSize (1GiB).
+const MaxBitsLimit = 30
+
 // Allocator manages a set of power-of-two sized byte slice pools.
 //
-// Pool index i holds buffers of size 1<<i, for i in [0, 16], i.e. 1B..64KiB.
+// Pool index i holds buffers of size 1<<(minBits+i), for i in
+// [0, maxBits-minBits], i.e. 1B..64KiB for NewAllocator.
 type Allocator struct {
-	buffers []sync.Pool
+	minBits     int                         // log2 of the smallest pooled size
+	maxSize     int                         // 1 << maxBits
+	pools       atomic.Pointer[[]sync.Pool] // swapped by Clear
+	counters    []classCounters             // parallel to the pools
+	putRejected atomic.Uint64
+	oversize    atomic.Pointer[func(requested int)]
+	metrics     atomic.Pointer[func(AllocEvent)]
+	zeroOnPut   atomic.Bool
+	debug       atomic.Pointer[tracker] // non-nil in debug mode
+}
+
+// classCounters are the live statistics of one pool.
+type classCounters struct {
+	gets   atomic.Uint64
+	misses atomic.Uint64
+	puts   atomic.Uint64
+}
+
+// ClassStats are the statistics of one size class.
+type ClassStats struct {
+	Size   int    // capacity of the buffers in the class
+	Gets   uint64 // Get calls served by the class
+	Misses uint64 // Gets that had to allocate a new buffer
+	Puts   uint64 // buffers returned to the class
+}
+
+// Reused returns the number of Gets served with a pooled buffer.
+func (c ClassStats) Reused() uint64 {
+	if c.Misses > c.Gets {
+		return 0
+	}
+	return c.Gets - c.Misses
+}
+
+// StatsSnapshot holds an allocator's counters. The counters are read
+// one at a time while the allocator may be in use, so they are not an
+// atomic snapshot; Misses may briefly exceed Gets.
+type StatsSnapshot struct {
+	Classes     []ClassStats // indexed like ClassOf
+	PutRejected uint64       // Put calls that returned an error
 }
 
 // defaultAllocator is the package-level allocator used by Get/Put.
@@ -21,23 +68,58 @@ var defaultAllocator = NewAllocator()
 
 // NewAllocator creates a new Allocator with pools for 1B..64KiB.
 func NewAllocator() *Allocator {
-	const maxBits = 16 // 2^16 = 65536
+	return newAllocator(0, 16) // 2^16 = 65536
+}
 
-	a := &Allocator{
-		buffers: make([]sync.Pool, maxBits+1),
+// NewAllocatorSize creates an Allocator with pools for 1B..1<<maxBits, e.g.
+// 18 for 256KiB buffers. maxBits must be in [0, MaxBitsLimit].
+func NewAllocatorSize(maxBits int) (*Allocator, error) {
+	if maxBits < 0 || maxBits > MaxBitsLimit {
+		return nil, fmt.Errorf("alloc: maxBits %d out of range [0, %d]", maxBits, MaxBitsLimit)
 	}
+	return newAllocator(0, maxBits), nil
+}
 
-	for i := range a.buffers {
-		size := 1 << uint(i)
-		a.buffers[i].New = func() any {
-			// allocate a slice of the exact power-of-two size
-			return make([]byte, size)
-		}
+// NewAllocatorRange creates an Allocator with pools for 1<<minBits..1<<maxBits
+// only. Requests below 1<<minBits are served by make and never pooled, which
+// skips size classes too small to be worth the pool overhead; Put rejects
+// such buffers. It requires 0 <= minBits <= maxBits <= MaxBitsLimit.
+func NewAllocatorRange(minBits, maxBits int) (*Allocator, error) {
+	if maxBits < 0 || maxBits > MaxBitsLimit {
+		return nil, fmt.Errorf("alloc: maxBits %d out of range [0, %d]", maxBits, MaxBitsLimit)
 	}
+	if minBits < 0 || minBits > maxBits {
+		return nil, fmt.Errorf("alloc: minBits %d out of range [0, %d]", minBits, maxBits)
+	}
+	return newAllocator(minBits, maxBits), nil
+}
 
+func newAllocator(minBits, maxBits int) *Allocator {
+	a := &Allocator{
+		minBits:  minBits,
+		maxSize:  1 << maxBits,
+		counters: make([]classCounters, maxBits-minBits+1),
+	}
+	a.pools.Store(a.newPools())
 	return a
 }
 
+// newPools returns an empty pool for each size class. The pools have no
+// New func so that take can tell hits from misses.
+func (a *Allocator) newPools() *[]sync.Pool {
+	pools := make([]sync.Pool, len(a.counters))
+	return &pools
+}
+
+// Clear drops every cached buffer by replacing the pools with empty ones,
+// e.g. to release memory after a load spike instead of waiting for the
+// garbage collector. Subsequent Gets allocate afresh and count as misses.
+// It is safe to call concurrently with Get and Put; a Put racing with Clear
+// may land in the discarded pools.
+func (a *Allocator) Clear() {
+	a.pools.Store(a.newPools())
+}
+
 // msb returns floor(log2(size)) for size > 0.
 // For example: msb(1)=0, msb(2)=1, msb(3)=1, msb(4)=2.
 func msb(size int) int {
@@ -47,53 +129,360 @@ func msb(size int) int {
 	return bits.Len(uint(size)) - 1
 }
 
+// ClassOf returns the pool index serving size and the capacity of the
+// buffers in that pool. ok is false if size is outside [a.Min(), a.Max()].
+func (a *Allocator) ClassOf(size int) (index, capacity int, ok bool) {
+	if size < a.Min() || size <= 0 || size > a.maxSize {
+		return 0, 0, false
+	}
+
+	bits := msb(size)
+	if size != 1<<bits {
+		bits++
+	}
+	idx := bits - a.minBits
+	if idx < 0 || idx >= len(a.counters) {
+		return 0, 0, false
+	}
+	return idx, 1 << bits, true
+}
+
+// Min returns the smallest buffer size the allocator pools.
+func (a *Allocator) Min() int {
+	return 1 << a.minBits
+}
+
+// Max returns the largest buffer size the allocator pools.
+func (a *Allocator) Max() int {
+	return a.maxSize
+}
+
+// SetOversizeHook installs fn to be called with the requested size whenever
+// a request exceeds a.Max() and therefore cannot be served from the pools.
+// It runs synchronously on the caller's goroutine, so it should be cheap
+// (e.g. increment a counter). A nil fn removes the hook.
+func (a *Allocator) SetOversizeHook(fn func(requested int)) {
+	if fn == nil {
+		a.oversize.Store(nil)
+		return
+	}
+	a.oversize.Store(&fn)
+}
+
+// AllocOp is the operation an AllocEvent reports.
+type AllocOp uint8
+
+const (
+	OpGet AllocOp = iota // a buffer was handed out
+	OpPut                // a buffer was accepted back into its pool
+)
+
+// AllocEvent describes one pooled Get or Put, for SetMetricsHook.
+type AllocEvent struct {
+	Op    AllocOp
+	Class int  // pool index, as returned by ClassOf
+	Size  int  // capacity of the buffers in the class
+	Hit   bool // OpGet only: served from the pool, not freshly allocated
+}
+
+// SetMetricsHook installs fn to be called on every Get and accepted Put of
+// a pooled size class, e.g. to feed hit-rate counters to a metrics system
+// without polling Stats. Batch calls report one event per buffer; requests
+// that bypass the pools (oversize, below a.Min(), rejected Puts) report
+// none. fn runs synchronously on the caller's goroutine with no lock held,
+// so it may allocate, but it should be cheap, and getting buffers from the
+// same allocator inside fn re-enters it. A nil fn removes the hook; unset,
+// the cost is a single atomic load per call.
+func (a *Allocator) SetMetricsHook(fn func(event AllocEvent)) {
+	if fn == nil {
+		a.metrics.Store(nil)
+		return
+	}
+	a.metrics.Store(&fn)
+}
+
+// SetZeroOnPut makes Put clear each buffer before pooling it, so that
+// secrets such as keys or tokens do not survive into the next Get. It costs
+// a memset of the whole capacity per Put and is off by default, including
+// for the package-level allocator.
+func (a *Allocator) SetZeroOnPut(on bool) {
+	a.zeroOnPut.Store(on)
+}
+
+// reportOversize calls the oversize hook, if any.
+func (a *Allocator) reportOversize(requested int) {
+	if fn := a.oversize.Load(); fn != nil {
+		(*fn)(requested)
+	}
+}
+
 // Get returns a byte slice with length == size and capacity being
-// the smallest power of two >= size, with an upper bound of MaxSize.
-// If size <= 0 or size > MaxSize, it returns nil.
+// the smallest power of two >= size, with an upper bound of a.Max().
+// If size <= 0 or size > a.Max(), it returns nil. Sizes below a.Min() are
+// allocated with make and not pooled.
 func (a *Allocator) Get(size int) []byte {
-	if size <= 0 || size > MaxSize {
+	idx, _, ok := a.ClassOf(size)
+	if !ok {
+		if size > 0 && size < a.Min() {
+			return make([]byte, size)
+		}
+		if size > a.maxSize {
+			a.reportOversize(size)
+		}
 		return nil
 	}
 
-	idx := msb(size)
-	if size != 1<<idx {
-		idx++
+	a.counters[idx].gets.Add(1)
+	// shrink length to requested size but keep capacity (power of two)
+	return a.take(&(*a.pools.Load())[idx], idx, a.debug.Load(), a.metrics.Load())[:size]
+}
+
+// take returns a full-capacity buffer of class idx from pool, allocating
+// one on a miss. t is the debug tracker and fn the metrics hook.
+func (a *Allocator) take(pool *sync.Pool, idx int, t *tracker, fn *func(AllocEvent)) []byte {
+	size := 1 << uint(a.minBits+idx)
+	buf, hit := pool.Get().([]byte)
+	if !hit {
+		a.counters[idx].misses.Add(1)
+		// allocate a slice of the exact power-of-two size
+		buf = make([]byte, size)
+	}
+	if fn != nil {
+		(*fn)(AllocEvent{Op: OpGet, Class: idx, Size: size, Hit: hit})
+	}
+	if t != nil {
+		t.issued(buf)
+		if a.zeroOnPut.Load() {
+			clear(buf) // replace the poison
+		}
 	}
-	if idx < 0 || idx >= len(a.buffers) {
+	return buf
+}
+
+// GetN returns count buffers of length size, as count calls to Get but
+// looking up the size class and pool once. It returns nil if count <= 0 or
+// size is out of range.
+func (a *Allocator) GetN(size, count int) [][]byte {
+	if count <= 0 {
+		return nil
+	}
+	idx, _, ok := a.ClassOf(size)
+	if !ok {
+		if size > 0 && size < a.Min() {
+			bufs := make([][]byte, count)
+			for i := range bufs {
+				bufs[i] = make([]byte, size)
+			}
+			return bufs
+		}
+		if size > a.maxSize {
+			a.reportOversize(size)
+		}
 		return nil
 	}
 
-	buf := a.buffers[idx].Get().([]byte)
-	// shrink length to requested size but keep capacity (power of two)
-	return buf[:size]
+	a.counters[idx].gets.Add(uint64(count))
+	pool := &(*a.pools.Load())[idx]
+	t, fn := a.debug.Load(), a.metrics.Load()
+	bufs := make([][]byte, count)
+	for i := range bufs {
+		bufs[i] = a.take(pool, idx, t, fn)[:size]
+	}
+	return bufs
+}
+
+// GetZeroed is Get returning size zero bytes. Get hands back whatever the
+// previous user of a pooled buffer left in it; GetZeroed clears it first,
+// for callers that rely on zeroed memory like make does.
+func (a *Allocator) GetZeroed(size int) []byte {
+	buf := a.Get(size)
+	clear(buf)
+	return buf
+}
+
+// GetExact returns a new, unpooled buffer whose length and capacity are
+// exactly size, avoiding the up to 2x overhead of Get's power-of-two
+// rounding for large odd sizes. It returns nil if size <= 0. Such buffers
+// must not be passed to Put: Put rejects most of them, but would pool one
+// whose size happens to be a power of two.
+func (a *Allocator) GetExact(size int) []byte {
+	if size <= 0 {
+		return nil
+	}
+	return make([]byte, size)
+}
+
+// GetElems returns a buffer with length == elemSize*count, for arrays of
+// fixed-size records. It returns nil if either argument is <= 0 or the
+// product overflows or exceeds a.Max().
+func (a *Allocator) GetElems(elemSize, count int) []byte {
+	if elemSize <= 0 || count <= 0 {
+		return nil
+	}
+	// division avoids overflowing the multiplication
+	if count > a.maxSize/elemSize {
+		if count <= math.MaxInt/elemSize {
+			a.reportOversize(elemSize * count)
+		}
+		return nil
+	}
+	return a.Get(elemSize * count)
 }
 
 // Put returns a buffer to the allocator.
 //
-// The capacity of buf must be a power of two and <= MaxSize.
+// The capacity of buf must be a power of two and <= a.Max().
 // Otherwise, Put returns an error and does not store the buffer.
 func (a *Allocator) Put(buf []byte) error {
+	idx, err := a.classOfPut(buf)
+	if err != nil {
+		a.putRejected.Add(1)
+		return err
+	}
+
+	a.store(*a.pools.Load(), idx, buf, a.debug.Load(), a.metrics.Load())
+	return nil
+}
+
+// store puts buf, already validated for class idx, into pools.
+func (a *Allocator) store(pools []sync.Pool, idx int, buf []byte, t *tracker, fn *func(AllocEvent)) {
+	// Reset length to full capacity before putting back.
+	buf = buf[:cap(buf)]
+	if t != nil {
+		t.returned(buf)
+	} else if a.zeroOnPut.Load() {
+		clear(buf)
+	}
+	a.counters[idx].puts.Add(1)
+	pools[idx].Put(buf)
+	if fn != nil {
+		(*fn)(AllocEvent{Op: OpPut, Class: idx, Size: cap(buf)})
+	}
+}
+
+// PutNError reports the buffers PutN rejected.
+type PutNError struct {
+	Indexes []int   // positions in the bufs argument
+	Errs    []error // the Put error of each, parallel to Indexes
+}
+
+func (e *PutNError) Error() string {
+	return fmt.Sprintf("alloc: PutN rejected %d buffer(s) at %v: %v", len(e.Indexes), e.Indexes, e.Errs[0])
+}
+
+// Unwrap returns the individual errors.
+func (e *PutNError) Unwrap() []error {
+	return e.Errs
+}
+
+// PutN returns every buffer of bufs to the allocator, validating each as
+// Put does. Valid buffers are stored even if others are rejected; the
+// rejected ones are reported in a *PutNError.
+func (a *Allocator) PutN(bufs [][]byte) error {
+	pools := *a.pools.Load()
+	t, fn := a.debug.Load(), a.metrics.Load()
+	var perr *PutNError
+	for i, buf := range bufs {
+		idx, err := a.classOfPut(buf)
+		if err != nil {
+			a.putRejected.Add(1)
+			if perr == nil {
+				perr = &PutNError{}
+			}
+			perr.Indexes = append(perr.Indexes, i)
+			perr.Errs = append(perr.Errs, err)
+			continue
+		}
+		a.store(pools, idx, buf, t, fn)
+	}
+	if perr != nil {
+		return perr
+	}
+	return nil
+}
+
+// classOfPut returns the pool index buf belongs to, or why Put rejects it.
+func (a *Allocator) classOfPut(buf []byte) (int, error) {
 	if buf == nil {
-		return errors.New("alloc: Put(nil)")
+		return 0, errors.New("alloc: Put(nil)")
 	}
 	c := cap(buf)
-	if c <= 0 || c > MaxSize {
-		return errors.New("alloc: Put() incorrect buffer size")
+	if c <= 0 || c > a.maxSize {
+		return 0, errors.New("alloc: Put() incorrect buffer size")
 	}
 	// capacity must be power of two
 	if c&(c-1) != 0 {
-		return errors.New("alloc: Put() incorrect buffer size (not power of two)")
+		return 0, errors.New("alloc: Put() incorrect buffer size (not power of two)")
+	}
+	if c < a.Min() {
+		return 0, errors.New("alloc: Put() buffer below the minimum pooled size")
 	}
 
-	idx := msb(c)
-	if idx < 0 || idx >= len(a.buffers) {
-		return errors.New("alloc: Put() invalid pool index")
+	idx := msb(c) - a.minBits
+	if idx < 0 || idx >= len(a.counters) {
+		return 0, errors.New("alloc: Put() invalid pool index")
 	}
+	return idx, nil
+}
 
-	// Reset length to full capacity before putting back.
-	buf = buf[:c]
-	a.buffers[idx].Put(buf)
-	return nil
+// Realloc resizes buf to newSize bytes, keeping its first
+// min(len(buf), newSize) bytes. If newSize falls in the size class of buf's
+// capacity, buf is resliced in place. Otherwise a new buffer is taken with
+// Get, or allocated with make above a.Max(), and buf is returned to the
+// pool if Put accepts it; buf must not be used afterwards. A newSize <= 0
+// puts buf back and returns nil.
+func (a *Allocator) Realloc(buf []byte, newSize int) []byte {
+	if newSize <= 0 {
+		if buf != nil {
+			_ = a.Put(buf)
+		}
+		return nil
+	}
+	_, capacity, ok := a.ClassOf(newSize)
+	if ok && buf != nil && cap(buf) == capacity {
+		return buf[:newSize]
+	}
+
+	nb := a.Get(newSize) // make below a.Min()
+	if nb == nil {
+		// Get reported the oversize request
+		nb = make([]byte, newSize)
+	}
+	copy(nb, buf)
+	if buf != nil {
+		_ = a.Put(buf)
+	}
+	return nb
+}
+
+// Stats returns the allocator's counters.
+func (a *Allocator) Stats() StatsSnapshot {
+	st := StatsSnapshot{
+		Classes:     make([]ClassStats, len(a.counters)),
+		PutRejected: a.putRejected.Load(),
+	}
+	for i := range a.counters {
+		c := &a.counters[i]
+		st.Classes[i] = ClassStats{
+			Size:   1 << (a.minBits + i),
+			Gets:   c.gets.Load(),
+			Misses: c.misses.Load(),
+			Puts:   c.puts.Load(),
+		}
+	}
+	return st
+}
+
+// SizeHistogram returns the number of buffers requested from each size
+// class, indexed like ClassOf, to show which sizes a workload uses when
+// tuning the pool range. It is the Gets column of Stats and costs nothing
+// beyond the counter Get already keeps.
+func (a *Allocator) SizeHistogram() []uint64 {
+	h := make([]uint64, len(a.counters))
+	for i := range a.counters {
+		h[i] = a.counters[i].gets.Load()
+	}
+	return h
 }
 
 // Get is a convenience wrapper around the package-level default allocator.
@@ -101,6 +490,57 @@ func Get(size int) []byte {
 	return defaultAllocator.Get(size)
 }
 
+// GetZeroed is a convenience wrapper around the package-level default allocator.
+func GetZeroed(size int) []byte {
+	return defaultAllocator.GetZeroed(size)
+}
+
+// GetExact is a convenience wrapper around the package-level default allocator.
+func GetExact(size int) []byte {
+	return defaultAllocator.GetExact(size)
+}
+
+// GetN is a convenience wrapper around the package-level default allocator.
+func GetN(size, count int) [][]byte {
+	return defaultAllocator.GetN(size, count)
+}
+
+// PutN returns buffers to the package-level default allocator.
+func PutN(bufs [][]byte) error {
+	return defaultAllocator.PutN(bufs)
+}
+
+// GetElems is a convenience wrapper around the package-level default allocator.
+func GetElems(elemSize, count int) []byte {
+	return defaultAllocator.GetElems(elemSize, count)
+}
+
+// SetOversizeHook installs fn on the package-level default allocator.
+func SetOversizeHook(fn func(requested int)) {
+	defaultAllocator.SetOversizeHook(fn)
+}
+
+// Realloc is a convenience wrapper around the package-level default allocator.
+func Realloc(buf []byte, newSize int) []byte {
+	return defaultAllocator.Realloc(buf, newSize)
+}
+
+// Clear drops the cached buffers of the package-level default allocator.
+func Clear() {
+	defaultAllocator.Clear()
+}
+
+// Stats returns the counters of the package-level default allocator.
+func Stats() StatsSnapshot {
+	return defaultAllocator.Stats()
+}
+
+// SizeHistogram returns the per-class request counts of the package-level
+// default allocator.
+func SizeHistogram() []uint64 {
+	return defaultAllocator.SizeHistogram()
+}
+
 // Put returns a buffer to the package-level default allocator.
 func Put(buf []byte) error {
 	return defaultAllocator.Put(buf)
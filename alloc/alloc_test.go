@@ -1,8 +1,11 @@
 package alloc
 
 import (
+	"errors"
+	"math"
 	"math/bits"
 	"math/rand"
+	"sync"
 	"testing"
 )
 
@@ -90,6 +93,532 @@ func TestAllocatorReuse(t *testing.T) {
 	}
 }
 
+func TestAllocatorGetElems(t *testing.T) {
+	a := NewAllocator()
+
+	if b := a.GetElems(12, 10); len(b) != 120 || cap(b) != 128 {
+		t.Fatalf("GetElems(12, 10): len=%d cap=%d, want len=120 cap=128", len(b), cap(b))
+	}
+
+	if b := a.GetElems(1024, 64); len(b) != MaxSize {
+		t.Fatalf("GetElems(1024, 64): len=%d, want %d", len(b), MaxSize)
+	}
+
+	if a.GetElems(1024, 65) != nil {
+		t.Fatal("GetElems(1024, 65) should return nil (exceeds MaxSize)")
+	}
+
+	if a.GetElems(0, 10) != nil || a.GetElems(10, 0) != nil || a.GetElems(-1, 10) != nil {
+		t.Fatal("GetElems with non-positive arguments should return nil")
+	}
+
+	// would overflow int if multiplied naively
+	if a.GetElems(math.MaxInt/2, 3) != nil {
+		t.Fatal("GetElems with overflowing product should return nil")
+	}
+}
+
+func TestAllocatorClassOf(t *testing.T) {
+	a := NewAllocator()
+
+	tests := []struct {
+		size, index, capacity int
+		ok                    bool
+	}{
+		{1, 0, 1, true},
+		{3, 2, 4, true},
+		{4, 2, 4, true},
+		{1000, 10, 1024, true},
+		{MaxSize, 16, MaxSize, true},
+		{0, 0, 0, false},
+		{-1, 0, 0, false},
+		{MaxSize + 1, 0, 0, false},
+	}
+	for _, tt := range tests {
+		idx, c, ok := a.ClassOf(tt.size)
+		if idx != tt.index || c != tt.capacity || ok != tt.ok {
+			t.Errorf("ClassOf(%d) = (%d, %d, %v), want (%d, %d, %v)",
+				tt.size, idx, c, ok, tt.index, tt.capacity, tt.ok)
+		}
+		if ok {
+			if b := a.Get(tt.size); cap(b) != c {
+				t.Errorf("Get(%d) cap=%d, ClassOf capacity=%d", tt.size, cap(b), c)
+			}
+		}
+	}
+}
+
+func TestAllocatorOversizeHook(t *testing.T) {
+	a := NewAllocator()
+
+	// no hook installed
+	if a.Get(MaxSize+1) != nil {
+		t.Fatal("Get(MaxSize+1) should return nil")
+	}
+
+	var got []int
+	a.SetOversizeHook(func(requested int) { got = append(got, requested) })
+
+	a.Get(MaxSize)
+	a.Get(0)
+	a.Get(MaxSize + 1)
+	a.GetElems(1024, 65)
+	a.GetElems(math.MaxInt/2, 3) // overflows, not reported
+	if len(got) != 2 || got[0] != MaxSize+1 || got[1] != 1024*65 {
+		t.Fatalf("oversize hook calls=%v, want [%d %d]", got, MaxSize+1, 1024*65)
+	}
+
+	a.SetOversizeHook(nil)
+	a.Get(MaxSize + 1)
+	if len(got) != 2 {
+		t.Fatalf("hook called after removal: %v", got)
+	}
+}
+
+func TestAllocatorStats(t *testing.T) {
+	a := NewAllocator()
+
+	b := a.Get(100) // class 7 (128B), new buffer
+	if err := a.Put(b); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	a.Get(128) // usually reused; sync.Pool may drop it
+	a.Get(0)
+	a.Get(MaxSize + 1)
+	_ = a.Put(make([]byte, 3))
+	_ = a.Put(nil)
+
+	st := a.Stats()
+	if len(st.Classes) != 17 {
+		t.Fatalf("len(Classes)=%d, want 17", len(st.Classes))
+	}
+	c := st.Classes[7]
+	if c.Size != 128 || c.Gets != 2 || c.Puts != 1 {
+		t.Fatalf("class 7 = %+v, want Size=128 Gets=2 Puts=1", c)
+	}
+	if c.Misses < 1 || c.Misses > 2 || c.Reused() != c.Gets-c.Misses {
+		t.Fatalf("class 7 Misses=%d Reused=%d", c.Misses, c.Reused())
+	}
+	if st.PutRejected != 2 {
+		t.Fatalf("PutRejected=%d, want 2", st.PutRejected)
+	}
+	for i, c := range st.Classes {
+		if i != 7 && (c.Gets != 0 || c.Puts != 0 || c.Misses != 0) {
+			t.Fatalf("class %d has counts %+v", i, c)
+		}
+	}
+}
+
+func TestAllocatorSizeHistogram(t *testing.T) {
+	a := NewAllocator()
+	for _, size := range []int{1, 2, 3, 4, 2000, 3000, 4096, MaxSize} {
+		_ = a.Get(size)
+	}
+	_ = a.GetN(100, 5)
+	_ = a.Get(0)
+	_ = a.Get(MaxSize + 1)
+
+	h := a.SizeHistogram()
+	if len(h) != 17 {
+		t.Fatalf("len=%d, want 17", len(h))
+	}
+	want := map[int]uint64{0: 1, 1: 1, 2: 2, 7: 5, 11: 1, 12: 2, 16: 1}
+	for i, n := range h {
+		if n != want[i] {
+			t.Fatalf("class %d: %d requests, want %d (histogram %v)", i, n, want[i], h)
+		}
+	}
+
+	r, _ := NewAllocatorRange(9, 16)
+	_ = r.Get(100) // below the floor, not counted
+	_ = r.Get(512)
+	if h := r.SizeHistogram(); len(h) != 8 || h[0] != 1 {
+		t.Fatalf("range histogram=%v", h)
+	}
+
+	before := SizeHistogram()[5]
+	_ = Get(32)
+	if got := SizeHistogram()[5]; got != before+1 {
+		t.Fatalf("default class 5: %d, want %d", got, before+1)
+	}
+}
+
+func TestNewAllocatorSize(t *testing.T) {
+	for _, bits := range []int{-1, MaxBitsLimit + 1} {
+		if _, err := NewAllocatorSize(bits); err == nil {
+			t.Fatalf("NewAllocatorSize(%d) should fail", bits)
+		}
+	}
+
+	a, err := NewAllocatorSize(18)
+	if err != nil {
+		t.Fatalf("NewAllocatorSize(18) error: %v", err)
+	}
+	const max = 256 * 1024
+	if a.Max() != max {
+		t.Fatalf("Max()=%d, want %d", a.Max(), max)
+	}
+	if b := a.Get(max); len(b) != max || cap(b) != max {
+		t.Fatalf("Get(%d): len=%d cap=%d", max, len(b), cap(b))
+	}
+	if b := a.Get(MaxSize + 1); cap(b) != 2*MaxSize {
+		t.Fatalf("Get(MaxSize+1) cap=%d, want %d", cap(b), 2*MaxSize)
+	}
+	if a.Get(max+1) != nil {
+		t.Fatalf("Get(%d) should return nil", max+1)
+	}
+	if err := a.Put(make([]byte, max)); err != nil {
+		t.Fatalf("Put(cap=%d) error: %v", max, err)
+	}
+	if err := a.Put(make([]byte, 2*max)); err == nil {
+		t.Fatalf("Put(cap=%d) should return error", 2*max)
+	}
+	if st := a.Stats(); len(st.Classes) != 19 {
+		t.Fatalf("len(Classes)=%d, want 19", len(st.Classes))
+	}
+
+	// the default allocator keeps the 64KiB limit
+	if Get(MaxSize+1) != nil || NewAllocator().Max() != MaxSize {
+		t.Fatal("default allocator should stay at MaxSize")
+	}
+
+	small, _ := NewAllocatorSize(0)
+	if b := small.Get(1); cap(b) != 1 || small.Get(2) != nil {
+		t.Fatal("maxBits 0 should only pool 1-byte buffers")
+	}
+}
+
+func TestNewAllocatorRange(t *testing.T) {
+	for _, r := range [][2]int{{-1, 16}, {17, 16}, {0, MaxBitsLimit + 1}} {
+		if _, err := NewAllocatorRange(r[0], r[1]); err == nil {
+			t.Fatalf("NewAllocatorRange(%d, %d) should fail", r[0], r[1])
+		}
+	}
+
+	a, err := NewAllocatorRange(9, 16)
+	if err != nil {
+		t.Fatalf("NewAllocatorRange(9, 16) error: %v", err)
+	}
+	if a.Min() != 512 || a.Max() != MaxSize {
+		t.Fatalf("Min()=%d Max()=%d", a.Min(), a.Max())
+	}
+
+	// just below the floor: exact-size make, not pooled
+	b := a.Get(511)
+	if len(b) != 511 || cap(b) != 511 {
+		t.Fatalf("Get(511): len=%d cap=%d", len(b), cap(b))
+	}
+	if _, _, ok := a.ClassOf(511); ok {
+		t.Fatal("ClassOf(511) should not be ok")
+	}
+	// at the floor: first pooled class
+	if b := a.Get(512); len(b) != 512 || cap(b) != 512 {
+		t.Fatalf("Get(512): len=%d cap=%d", len(b), cap(b))
+	}
+	if idx, c, ok := a.ClassOf(513); !ok || idx != 1 || c != 1024 {
+		t.Fatalf("ClassOf(513)=(%d, %d, %v)", idx, c, ok)
+	}
+
+	if err := a.Put(make([]byte, 256)); err == nil {
+		t.Fatal("Put(cap=256) below the floor should return error")
+	}
+	if err := a.Put(make([]byte, 512)); err != nil {
+		t.Fatalf("Put(cap=512) error: %v", err)
+	}
+
+	st := a.Stats()
+	if len(st.Classes) != 8 || st.Classes[0].Size != 512 || st.Classes[7].Size != MaxSize {
+		t.Fatalf("Classes=%+v", st.Classes)
+	}
+	if st.Classes[0].Gets != 1 || st.Classes[0].Puts != 1 || st.PutRejected != 1 {
+		t.Fatalf("stats=%+v", st)
+	}
+
+	bufs := a.GetN(100, 3)
+	if len(bufs) != 3 || cap(bufs[0]) != 100 {
+		t.Fatalf("GetN(100, 3) below the floor: %d bufs, cap=%d", len(bufs), cap(bufs[0]))
+	}
+	if r := a.Realloc(make([]byte, 10), 600); len(r) != 600 || cap(r) != 1024 {
+		t.Fatalf("Realloc to 600: len=%d cap=%d", len(r), cap(r))
+	}
+
+	// the default allocator still pools from 1 byte
+	if NewAllocator().Min() != 1 || cap(Get(1)) != 1 {
+		t.Fatal("default allocator should pool from 1 byte")
+	}
+}
+
+func TestAllocatorMetricsHook(t *testing.T) {
+	a := NewAllocator()
+	var events []AllocEvent
+	a.SetMetricsHook(func(ev AllocEvent) {
+		events = append(events, ev) // allocating in the hook is fine
+	})
+
+	b := a.Get(100) // class 7, fresh
+	if len(events) != 1 || events[0] != (AllocEvent{Op: OpGet, Class: 7, Size: 128}) {
+		t.Fatalf("events after Get=%+v", events)
+	}
+	if err := a.Put(b); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	if len(events) != 2 || events[1] != (AllocEvent{Op: OpPut, Class: 7, Size: 128}) {
+		t.Fatalf("events after Put=%+v", events)
+	}
+
+	// sync.Pool may drop a Put under the race detector, so retry for a hit
+	hit := false
+	for i := 0; i < 100 && !hit; i++ {
+		events = events[:0]
+		_ = a.Put(make([]byte, 4096))
+		_ = a.Get(3000)
+		ev := events[len(events)-1]
+		if ev.Op != OpGet || ev.Class != 12 || ev.Size != 4096 {
+			t.Fatalf("event=%+v", ev)
+		}
+		hit = ev.Hit
+	}
+	if !hit {
+		t.Fatal("hook never observed a pool hit")
+	}
+
+	// bypassing the pools reports nothing
+	events = events[:0]
+	_ = a.Get(MaxSize + 1)
+	_ = a.Put(make([]byte, 3))
+	if len(events) != 0 {
+		t.Fatalf("unexpected events=%+v", events)
+	}
+
+	a.SetMetricsHook(nil)
+	_ = a.Get(1)
+	if len(events) != 0 {
+		t.Fatal("hook called after removal")
+	}
+}
+
+func TestAllocatorZeroOnPut(t *testing.T) {
+	a := NewAllocator()
+	a.SetZeroOnPut(true)
+
+	secret := a.Get(60)
+	for i := range secret {
+		secret[i] = 0xFF
+	}
+	p := &secret[0]
+	if err := a.Put(secret); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	// the whole capacity is cleared, not just the length handed out
+	for i, c := range secret[:cap(secret)] {
+		if c != 0 {
+			t.Fatalf("byte %d not cleared on Put: %#x", i, c)
+		}
+	}
+
+	got := a.Get(64)
+	if &got[0] == p {
+		for i, c := range got {
+			if c != 0 {
+				t.Fatalf("reused buffer byte %d = %#x, want 0", i, c)
+			}
+		}
+	}
+}
+
+func TestAllocatorGetZeroed(t *testing.T) {
+	a := NewAllocator()
+	if a.GetZeroed(0) != nil || a.GetZeroed(MaxSize+1) != nil {
+		t.Fatal("GetZeroed out of range should return nil")
+	}
+
+	// sync.Pool may drop the dirty buffer, so retry until it is reused
+	for i := 0; i < 20; i++ {
+		dirty := a.Get(32)
+		for i := range dirty {
+			dirty[i] = 0xAA
+		}
+		p := &dirty[0]
+		_ = a.Put(dirty)
+
+		got := a.GetZeroed(30)
+		if len(got) != 30 || cap(got) != 32 {
+			t.Fatalf("GetZeroed(30): len=%d cap=%d", len(got), cap(got))
+		}
+		for i, c := range got {
+			if c != 0 {
+				t.Fatalf("GetZeroed byte %d = %#x, want 0", i, c)
+			}
+		}
+		if &got[0] == p {
+			return
+		}
+	}
+	t.Fatal("dirty buffer never reused")
+}
+
+func TestAllocatorRealloc(t *testing.T) {
+	a := NewAllocator()
+
+	// same class: resliced in place
+	b := a.Get(40)
+	copy(b, "0123456789")
+	p := &b[0]
+	b = a.Realloc(b, 60)
+	if len(b) != 60 || cap(b) != 64 || &b[0] != p || string(b[:10]) != "0123456789" {
+		t.Fatalf("same-class Realloc: len=%d cap=%d moved=%v", len(b), cap(b), &b[0] != p)
+	}
+
+	// cross-class grow: copied into a bigger pooled buffer, old one put back
+	puts := a.Stats().Classes[6].Puts
+	b = a.Realloc(b, 100)
+	if len(b) != 100 || cap(b) != 128 || string(b[:10]) != "0123456789" {
+		t.Fatalf("grow Realloc: len=%d cap=%d data=%q", len(b), cap(b), b[:10])
+	}
+	if got := a.Stats().Classes[6].Puts; got != puts+1 {
+		t.Fatalf("old buffer not put back: Puts=%d, want %d", got, puts+1)
+	}
+
+	// shrink across classes keeps the prefix
+	b = a.Realloc(b, 4)
+	if len(b) != 4 || cap(b) != 4 || string(b) != "0123" {
+		t.Fatalf("shrink Realloc: len=%d cap=%d data=%q", len(b), cap(b), b)
+	}
+
+	// above Max: plain make, not pool-managed
+	var oversize int
+	a.SetOversizeHook(func(n int) { oversize = n })
+	b = a.Realloc(b, MaxSize+1)
+	if len(b) != MaxSize+1 || string(b[:4]) != "0123" || oversize != MaxSize+1 {
+		t.Fatalf("oversize Realloc: len=%d data=%q hook=%d", len(b), b[:4], oversize)
+	}
+	rejected := a.Stats().PutRejected
+	b = a.Realloc(b, 10)
+	if cap(b) != 16 || string(b[:4]) != "0123" || a.Stats().PutRejected != rejected+1 {
+		t.Fatalf("Realloc from oversize: cap=%d rejected=%d", cap(b), a.Stats().PutRejected-rejected)
+	}
+
+	if a.Realloc(b, 0) != nil {
+		t.Fatal("Realloc to 0 should return nil")
+	}
+	if b := a.Realloc(nil, 5); len(b) != 5 || cap(b) != 8 {
+		t.Fatalf("Realloc(nil, 5): len=%d cap=%d", len(b), cap(b))
+	}
+}
+
+func TestAllocatorClear(t *testing.T) {
+	a := NewAllocator()
+	bufs := make([][]byte, 8)
+	for i := range bufs {
+		bufs[i] = a.Get(1024)
+	}
+	for _, b := range bufs {
+		_ = a.Put(b)
+	}
+
+	a.Clear()
+	before := a.Stats().Classes[10]
+	for range bufs {
+		a.Get(1024)
+	}
+	after := a.Stats().Classes[10]
+	if got := after.Misses - before.Misses; got != uint64(len(bufs)) {
+		t.Fatalf("%d of %d Gets after Clear were misses", got, len(bufs))
+	}
+}
+
+func TestAllocatorClearConcurrent(t *testing.T) {
+	a := NewAllocator()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if err := a.Put(a.Get(j%MaxSize + 1)); err != nil {
+					t.Errorf("Put error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		a.Clear()
+	}
+	wg.Wait()
+}
+
+func TestAllocatorGetN(t *testing.T) {
+	a := NewAllocator()
+	bufs := a.GetN(24, 10)
+	if len(bufs) != 10 {
+		t.Fatalf("GetN returned %d buffers, want 10", len(bufs))
+	}
+	for i, b := range bufs {
+		if len(b) != 24 || cap(b) != 32 {
+			t.Fatalf("bufs[%d]: len=%d cap=%d", i, len(b), cap(b))
+		}
+	}
+	if got := a.Stats().Classes[5].Gets; got != 10 {
+		t.Fatalf("class 5 Gets=%d, want 10", got)
+	}
+	if err := a.PutN(bufs); err != nil {
+		t.Fatalf("PutN error: %v", err)
+	}
+	if got := a.Stats().Classes[5].Puts; got != 10 {
+		t.Fatalf("class 5 Puts=%d, want 10", got)
+	}
+
+	if a.GetN(24, 0) != nil || a.GetN(24, -1) != nil {
+		t.Fatal("GetN with count <= 0 should return nil")
+	}
+	if a.GetN(0, 3) != nil || a.GetN(MaxSize+1, 3) != nil {
+		t.Fatal("GetN with an out-of-range size should return nil")
+	}
+	if err := a.PutN(nil); err != nil {
+		t.Fatalf("PutN(nil) error: %v", err)
+	}
+}
+
+func TestAllocatorPutNRejected(t *testing.T) {
+	a := NewAllocator()
+	mixed := [][]byte{a.Get(8), make([]byte, 3), a.Get(16), nil}
+
+	err := a.PutN(mixed)
+	var perr *PutNError
+	if !errors.As(err, &perr) {
+		t.Fatalf("PutN error %v, want *PutNError", err)
+	}
+	if len(perr.Indexes) != 2 || perr.Indexes[0] != 1 || perr.Indexes[1] != 3 {
+		t.Fatalf("rejected indexes %v, want [1 3]", perr.Indexes)
+	}
+	st := a.Stats()
+	if st.Classes[3].Puts != 1 || st.Classes[4].Puts != 1 || st.PutRejected != 2 {
+		t.Fatalf("valid buffers not stored: %+v %+v rejected=%d", st.Classes[3], st.Classes[4], st.PutRejected)
+	}
+}
+
+func TestAllocatorGetExact(t *testing.T) {
+	a := NewAllocator()
+	for _, size := range []int{3, 1000, 33000, MaxSize + 1, 3 * MaxSize} {
+		b := a.GetExact(size)
+		if len(b) != size || cap(b) != size {
+			t.Fatalf("GetExact(%d): len=%d cap=%d", size, len(b), cap(b))
+		}
+		if err := a.Put(b); err == nil {
+			t.Fatalf("Put accepted a GetExact(%d) buffer", size)
+		}
+	}
+	if a.GetExact(0) != nil || a.GetExact(-1) != nil {
+		t.Fatal("GetExact with size <= 0 should return nil")
+	}
+	for _, c := range a.Stats().Classes {
+		if c.Gets != 0 {
+			t.Fatalf("GetExact went through the pools: %+v", c)
+		}
+	}
+}
+
 func BenchmarkMSB(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_ = bits.Len(uint(rand.Intn(MaxSize) + 1))
@@ -0,0 +1,103 @@
+package alloc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrDoublePut reports a buffer put back twice without a Get in between.
+	ErrDoublePut = errors.New("alloc: buffer put twice")
+
+	// ErrForeignPut reports a Put of a buffer this allocator never issued.
+	ErrForeignPut = errors.New("alloc: buffer not issued by this allocator")
+
+	// ErrUseAfterPut reports a pooled buffer that was written to after Put.
+	ErrUseAfterPut = errors.New("alloc: buffer modified after Put")
+)
+
+// poison fills pooled buffers in debug mode, so that writes through a
+// slice retained after Put can be noticed on the next Get.
+const poison = 0xDB
+
+// tracker records the state of every buffer in debug mode, keyed by the
+// first byte of its full capacity.
+type tracker struct {
+	sync.Mutex
+	out    map[*byte]struct{} // issued by Get, not yet put back
+	pooled map[*byte]struct{} // put back and poisoned
+}
+
+// SetDebug turns on misuse detection, a development aid. While on, the
+// allocator tracks every buffer and panics with an error wrapping
+// ErrDoublePut, ErrForeignPut or ErrUseAfterPut when a buffer is put back
+// twice, put back without having been issued by Get, or written to after
+// Put (detected on its next Get, best effort). It is expensive and keeps
+// every buffer it has seen alive, so enable it before the allocator is
+// used and only in tests or debug builds. When off, the only cost is a nil
+// check per call.
+func (a *Allocator) SetDebug(on bool) {
+	if !on {
+		a.debug.Store(nil)
+		return
+	}
+	a.debug.Store(&tracker{
+		out:    make(map[*byte]struct{}),
+		pooled: make(map[*byte]struct{}),
+	})
+}
+
+// Outstanding returns the number of buffers issued by Get and not yet put
+// back, e.g. to detect leaks at the end of a test. It is only tracked in
+// debug mode and returns 0 otherwise.
+func (a *Allocator) Outstanding() int {
+	t := a.debug.Load()
+	if t == nil {
+		return 0
+	}
+	t.Lock()
+	defer t.Unlock()
+	return len(t.out)
+}
+
+// issued records buf, freshly taken from a pool, as outstanding.
+func (t *tracker) issued(buf []byte) {
+	key := &buf[:cap(buf)][0]
+	t.Lock()
+	_, wasPooled := t.pooled[key]
+	delete(t.pooled, key)
+	t.out[key] = struct{}{}
+	t.Unlock()
+
+	if wasPooled {
+		for i, c := range buf[:cap(buf)] {
+			if c != poison {
+				panic(fmt.Errorf("%w: %d-byte buffer, offset %d", ErrUseAfterPut, cap(buf), i))
+			}
+		}
+	}
+}
+
+// returned records buf, about to be pooled, and poisons it.
+func (t *tracker) returned(buf []byte) {
+	key := &buf[:cap(buf)][0]
+	t.Lock()
+	_, isOut := t.out[key]
+	_, isPooled := t.pooled[key]
+	if isOut {
+		delete(t.out, key)
+		t.pooled[key] = struct{}{}
+	}
+	t.Unlock()
+
+	switch {
+	case isPooled:
+		panic(fmt.Errorf("%w: %d-byte buffer", ErrDoublePut, cap(buf)))
+	case !isOut:
+		panic(fmt.Errorf("%w: %d-byte buffer", ErrForeignPut, cap(buf)))
+	}
+	for i := range buf[:cap(buf)] {
+		buf[i] = poison
+	}
+}
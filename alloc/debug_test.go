@@ -0,0 +1,92 @@
+package alloc
+
+import (
+	"errors"
+	"testing"
+)
+
+// mustPanicWith runs fn and checks that it panics with an error wrapping want.
+func mustPanicWith(t *testing.T, want error, fn func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		err, _ := recover().(error)
+		if !errors.Is(err, want) {
+			t.Fatalf("panic %v, want %v", err, want)
+		}
+	}()
+	fn()
+}
+
+func TestDebugDoublePut(t *testing.T) {
+	a := NewAllocator()
+	a.SetDebug(true)
+
+	b := a.Get(10)
+	if a.Outstanding() != 1 {
+		t.Fatalf("Outstanding=%d, want 1", a.Outstanding())
+	}
+	if err := a.Put(b); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	if a.Outstanding() != 0 {
+		t.Fatalf("Outstanding=%d after Put, want 0", a.Outstanding())
+	}
+	mustPanicWith(t, ErrDoublePut, func() { _ = a.Put(b) })
+}
+
+func TestDebugForeignPut(t *testing.T) {
+	a := NewAllocator()
+	a.SetDebug(true)
+	mustPanicWith(t, ErrForeignPut, func() { _ = a.Put(make([]byte, 16)) })
+
+	// a buffer issued by another allocator is foreign too
+	other := NewAllocator()
+	mustPanicWith(t, ErrForeignPut, func() { _ = a.Put(other.Get(16)) })
+}
+
+func TestDebugUseAfterPut(t *testing.T) {
+	a := NewAllocator()
+	a.SetDebug(true)
+
+	// sync.Pool may drop the buffer, so retry until it is reused
+	for i := 0; i < 20; i++ {
+		b := a.Get(32)
+		p := &b[0]
+		_ = a.Put(b)
+		b[3] = 'x' // write through a retained slice
+
+		reused := false
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err, _ := r.(error)
+					if !errors.Is(err, ErrUseAfterPut) {
+						t.Fatalf("panic %v, want ErrUseAfterPut", r)
+					}
+					reused = true
+				}
+			}()
+			if got := a.Get(32); &got[0] != p {
+				_ = a.Put(got)
+			}
+		}()
+		if reused {
+			return
+		}
+	}
+	t.Fatal("modified buffer never reused")
+}
+
+func TestDebugOff(t *testing.T) {
+	a := NewAllocator()
+	a.SetDebug(true)
+	a.SetDebug(false)
+
+	b := a.Get(8)
+	_ = a.Put(b)
+	_ = a.Put(b) // not detected when off
+	if a.Outstanding() != 0 {
+		t.Fatalf("Outstanding=%d with debug off", a.Outstanding())
+	}
+}
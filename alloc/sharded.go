@@ -0,0 +1,81 @@
+package alloc
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ShardedAllocator spreads Get and Put over several independent
+// allocators to reduce contention on the hottest size classes under heavy
+// concurrency. Calls are routed by a shard hint kept in a sync.Pool, whose
+// per-P caching makes goroutines running on the same P mostly use the same
+// shard without any shared state. A buffer may be put back to a different
+// shard than it came from, which is harmless since all shards have the
+// same size classes.
+type ShardedAllocator struct {
+	shards []*Allocator
+	hints  sync.Pool // *int shard indexes
+	next   atomic.Uint32
+}
+
+// NewShardedAllocator creates an allocator of shards independent pool
+// sets, each covering 1B..64KiB like NewAllocator. shards must be positive;
+// runtime.GOMAXPROCS(0) is a reasonable choice.
+func NewShardedAllocator(shards int) (*ShardedAllocator, error) {
+	if shards <= 0 {
+		return nil, fmt.Errorf("alloc: shard count %d must be positive", shards)
+	}
+	s := &ShardedAllocator{shards: make([]*Allocator, shards)}
+	for i := range s.shards {
+		s.shards[i] = NewAllocator()
+	}
+	s.hints.New = func() any {
+		i := int((s.next.Add(1) - 1) % uint32(len(s.shards)))
+		return &i
+	}
+	return s, nil
+}
+
+// shard returns the allocator serving the current call.
+func (s *ShardedAllocator) shard() *Allocator {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	h := s.hints.Get().(*int)
+	a := s.shards[*h]
+	s.hints.Put(h)
+	return a
+}
+
+// Get is Allocator.Get served by one of the shards.
+func (s *ShardedAllocator) Get(size int) []byte {
+	return s.shard().Get(size)
+}
+
+// Put is Allocator.Put to one of the shards.
+func (s *ShardedAllocator) Put(buf []byte) error {
+	return s.shard().Put(buf)
+}
+
+// Clear drops the cached buffers of every shard.
+func (s *ShardedAllocator) Clear() {
+	for _, a := range s.shards {
+		a.Clear()
+	}
+}
+
+// Stats returns the counters summed over all shards.
+func (s *ShardedAllocator) Stats() StatsSnapshot {
+	st := s.shards[0].Stats()
+	for _, a := range s.shards[1:] {
+		sh := a.Stats()
+		st.PutRejected += sh.PutRejected
+		for i, c := range sh.Classes {
+			st.Classes[i].Gets += c.Gets
+			st.Classes[i].Misses += c.Misses
+			st.Classes[i].Puts += c.Puts
+		}
+	}
+	return st
+}
@@ -0,0 +1,94 @@
+package alloc
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestShardedAllocator(t *testing.T) {
+	if _, err := NewShardedAllocator(0); err == nil {
+		t.Fatal("NewShardedAllocator(0) should fail")
+	}
+
+	s, err := NewShardedAllocator(4)
+	if err != nil {
+		t.Fatalf("NewShardedAllocator error: %v", err)
+	}
+	if b := s.Get(100); len(b) != 100 || cap(b) != 128 {
+		t.Fatalf("Get(100): len=%d cap=%d", len(b), cap(b))
+	}
+	if s.Get(MaxSize+1) != nil {
+		t.Fatal("Get(MaxSize+1) should return nil")
+	}
+	if err := s.Put(make([]byte, 3)); err == nil {
+		t.Fatal("Put(cap=3) should return error")
+	}
+
+	// buffers may come back to any shard
+	var wg sync.WaitGroup
+	bufs := make(chan []byte, 64)
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				bufs <- s.Get(64)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if err := s.Put(<-bufs); err != nil {
+					t.Errorf("Put error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	st := s.Stats()
+	if c := st.Classes[6]; c.Gets != 400 || c.Puts != 400 {
+		t.Fatalf("class 6 summed stats %+v, want 400 Gets and Puts", c)
+	}
+	if st.Classes[7].Gets != 1 || st.PutRejected != 1 {
+		t.Fatalf("summed stats class 7 Gets=%d PutRejected=%d", st.Classes[7].Gets, st.PutRejected)
+	}
+
+	s.Clear()
+}
+
+func TestShardedAllocatorHintWraparound(t *testing.T) {
+	s, _ := NewShardedAllocator(3)
+	// past MaxInt32 the counter would turn negative as a 32-bit int
+	s.next.Store(math.MaxInt32 + 1)
+	for i := 0; i < 4; i++ {
+		if h := *s.hints.New().(*int); h < 0 || h >= 3 {
+			t.Fatalf("shard hint %d out of range", h)
+		}
+	}
+}
+
+func BenchmarkAllocatorParallel(b *testing.B) {
+	benchmarkParallel(b, NewAllocator())
+}
+
+func BenchmarkShardedAllocatorParallel(b *testing.B) {
+	s, _ := NewShardedAllocator(runtime.GOMAXPROCS(0))
+	benchmarkParallel(b, s)
+}
+
+func benchmarkParallel(b *testing.B, a interface {
+	Get(int) []byte
+	Put([]byte) error
+}) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buf := a.Get(512)
+			buf[0] = 1
+			_ = a.Put(buf)
+		}
+	})
+}
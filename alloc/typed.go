@@ -0,0 +1,56 @@
+package alloc
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// TypedPool is a pool of *T values, a typed counterpart of Allocator for
+// small fixed-size structs. It has a single class and no size limit.
+type TypedPool[T any] struct {
+	pool     sync.Pool
+	reset    func(*T)
+	counters classCounters
+}
+
+// NewTypedPool creates a pool of *T. reset, if non-nil, is applied to every
+// value on Put, e.g. to clear fields before it is reused; without it the
+// values keep their contents.
+func NewTypedPool[T any](reset func(*T)) *TypedPool[T] {
+	p := &TypedPool[T]{reset: reset}
+	p.pool.New = func() any {
+		p.counters.misses.Add(1)
+		return new(T)
+	}
+	return p
+}
+
+// Get returns a pooled *T, or a new zero value if the pool is empty.
+func (p *TypedPool[T]) Get() *T {
+	p.counters.gets.Add(1)
+	return p.pool.Get().(*T)
+}
+
+// Put resets v and returns it to the pool. v must not be used afterwards.
+// A nil v is ignored.
+func (p *TypedPool[T]) Put(v *T) {
+	if v == nil {
+		return
+	}
+	if p.reset != nil {
+		p.reset(v)
+	}
+	p.counters.puts.Add(1)
+	p.pool.Put(v)
+}
+
+// Stats returns the pool's counters, with Size the size of T in bytes.
+func (p *TypedPool[T]) Stats() ClassStats {
+	var zero T
+	return ClassStats{
+		Size:   int(unsafe.Sizeof(zero)),
+		Gets:   p.counters.gets.Load(),
+		Misses: p.counters.misses.Load(),
+		Puts:   p.counters.puts.Load(),
+	}
+}
@@ -0,0 +1,62 @@
+package alloc
+
+import "testing"
+
+type record struct {
+	id   int
+	name string
+	tags []string
+}
+
+func TestTypedPool(t *testing.T) {
+	resets := 0
+	p := NewTypedPool(func(r *record) {
+		resets++
+		r.id, r.name, r.tags = 0, "", r.tags[:0]
+	})
+
+	r := p.Get()
+	if r == nil || r.id != 0 {
+		t.Fatalf("Get returned %+v", r)
+	}
+	r.id, r.name, r.tags = 7, "seven", append(r.tags, "a", "b")
+	p.Put(r)
+	p.Put(nil)
+	if resets != 1 {
+		t.Fatalf("reset ran %d times, want 1", resets)
+	}
+	if r.id != 0 || r.name != "" || len(r.tags) != 0 {
+		t.Fatalf("value not reset on Put: %+v", r)
+	}
+
+	// sync.Pool may drop values, so retry until the last value put back
+	// is reused
+	reused := false
+	for last, i := r, 0; i < 20 && !reused; i++ {
+		got := p.Get()
+		reused = got == last
+		last = got
+		p.Put(got)
+	}
+	if !reused {
+		t.Fatal("pooled pointer never reused")
+	}
+
+	st := p.Stats()
+	if st.Size != 48 && st.Size != 24 { // 64-bit and 32-bit layouts
+		t.Fatalf("Stats().Size=%d", st.Size)
+	}
+	if st.Gets < 2 || st.Puts != st.Gets || st.Misses < 1 || st.Misses > st.Gets {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestTypedPoolNoReset(t *testing.T) {
+	p := NewTypedPool[record](nil)
+	r := p.Get()
+	r.id = 3
+	p.Put(r)
+	if r.id != 3 {
+		t.Fatal("value changed without a reset func")
+	}
+}
@@ -1,48 +1,137 @@
 package buffer
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"net"
+	"sync/atomic"
 
 	"github.com/ninepeach/ark/alloc"
 )
 
-// DefaultSize is the default buffer size used by New().
+// DefaultSize is the initial buffer size used by New() unless changed
+// with SetDefaultSize.
 const DefaultSize = 32 * 1024
 
+// defaultSize is the size New() currently uses.
+var defaultSize atomic.Int64
+
+func init() {
+	defaultSize.Store(DefaultSize)
+}
+
+// SetDefaultSize changes the capacity used by subsequent New() calls, e.g.
+// to match a protocol's typical frame size. n must be in (0, alloc.MaxSize]
+// so that New() always draws from the pool. Existing buffers are unaffected.
+func SetDefaultSize(n int) error {
+	if n <= 0 || n > alloc.MaxSize {
+		return fmt.Errorf("buffer: default size %d out of range (0, %d]", n, alloc.MaxSize)
+	}
+	defaultSize.Store(int64(n))
+	return nil
+}
+
+var (
+	// ErrIncomplete is returned when the readable region does not hold
+	// enough data yet. Nothing is consumed, so the call can be retried
+	// once more data has been written.
+	ErrIncomplete = errors.New("buffer: incomplete data")
+
+	// ErrPrefixMismatch is returned by ExpectPrefix when the data does not
+	// start with the expected bytes.
+	ErrPrefixMismatch = errors.New("buffer: prefix mismatch")
+
+	// ErrVarintOverflow is returned when a varint does not fit in 64 bits.
+	ErrVarintOverflow = errors.New("buffer: varint overflows a 64-bit integer")
+
+	// ErrNegativeCount is returned (or panicked with, depending on the
+	// buffer's NegativePolicy) when a method is given a negative size.
+	ErrNegativeCount = errors.New("buffer: negative count")
+
+	// ErrUnreadByte is returned by UnreadByte when the last operation was
+	// not a successful ReadByte.
+	ErrUnreadByte = errors.New("buffer: UnreadByte: previous operation was not a successful ReadByte")
+
+	// ErrBufferFull is returned when a write would grow a buffer created
+	// with NewSizeMax beyond its maximum capacity.
+	ErrBufferFull = errors.New("buffer: maximum capacity exceeded")
+)
+
+// NegativePolicy selects how a Buffer treats a negative size argument.
+type NegativePolicy int
+
+const (
+	// NegativeDefault keeps the historical behavior: Extend panics, methods
+	// returning an error return ErrNegativeCount, and To and TakeHeader
+	// return nothing.
+	NegativeDefault NegativePolicy = iota
+	// NegativePanic treats a negative size as a programming error and
+	// panics with ErrNegativeCount in every method.
+	NegativePanic
+	// NegativeError never panics: methods report ErrNegativeCount where
+	// they can, Extend and To return nil, and TakeHeader returns ok=false.
+	// Use TryExtend to get the error from Extend.
+	NegativeError
+)
+
 // Buffer is a simple growable byte buffer with read/write indexes.
 // It uses alloc.Get/Put for underlying storage when possible.
 type Buffer struct {
-	data   []byte
-	start  int // read index
-	end    int // write index (exclusive)
-	pooled bool
+	data      []byte
+	start     int // read index
+	end       int // write index (exclusive)
+	pooled    bool
+	allocator *alloc.Allocator // nil means the package-level allocator
+	compactAt int              // auto-compact once start reaches this; 0 disables
+	negPolicy NegativePolicy
+	unread    unreadState
+	maxCap    int // limit on len(data); 0 means unbounded
+}
+
+// unreadState remembers the last ReadByte for UnreadByte. Every write or
+// consuming read clears ok; the indexes additionally catch repositioning
+// such as Compact.
+type unreadState struct {
+	ok         bool
+	c          byte
+	start, end int
 }
 
-// New creates a buffer with DefaultSize capacity.
+// New creates a buffer with the default capacity (DefaultSize unless
+// changed with SetDefaultSize).
 func New() *Buffer {
-	return NewSize(DefaultSize)
+	return NewSize(int(defaultSize.Load()))
 }
 
 // NewSize creates a buffer with an initial capacity of size.
 // It uses alloc.Get(size) when possible; if alloc returns nil,
 // it falls back to make([]byte, size).
 func NewSize(size int) *Buffer {
+	return NewSizeWithAllocator(size, nil)
+}
+
+// NewSizeWithAllocator creates a buffer like NewSize whose storage comes
+// from and returns to a instead of the package-level allocator, e.g. to
+// give a subsystem an isolated pool with its own stats. A nil a means the
+// package-level allocator.
+func NewSizeWithAllocator(size int, a *alloc.Allocator) *Buffer {
 	if size < 0 {
 		size = 0
 	}
-	b := &Buffer{}
+	b := &Buffer{allocator: a}
 	if size == 0 {
 		b.data = nil
 		b.pooled = false
 		return b
 	}
 
-	data := alloc.Get(size)
+	data, pooled := b.getData(size)
 	if data != nil {
-		// data is pooled
 		b.data = data[:size]
-		b.pooled = true
+		b.pooled = pooled
 		return b
 	}
 
@@ -52,6 +141,20 @@ func NewSize(size int) *Buffer {
 	return b
 }
 
+// NewSizeMax creates a buffer like NewSize whose capacity never grows
+// beyond maxCap, e.g. to accumulate frames from an untrusted peer. Writes
+// that do not fit fail with ErrBufferFull; Write and ReadFrom first fill the
+// buffer up to the limit. A size above maxCap is reduced to it, and
+// maxCap <= 0 means no limit.
+func NewSizeMax(size, maxCap int) *Buffer {
+	if maxCap > 0 && size > maxCap {
+		size = maxCap
+	}
+	b := NewSize(size)
+	b.maxCap = max(maxCap, 0)
+	return b
+}
+
 // FromBytes wraps an existing byte slice as a Buffer (readable content = full slice).
 // It does not copy the data and does not use the pool.
 func FromBytes(b []byte) *Buffer {
@@ -68,6 +171,55 @@ func (b *Buffer) Bytes() []byte {
 	return b.data[b.start:b.end]
 }
 
+// String returns a copy of the readable region as a string without
+// consuming it, so a *Buffer prints its content with %s and %v. A nil
+// buffer returns "".
+func (b *Buffer) String() string {
+	if b == nil {
+		return ""
+	}
+	return string(b.data[b.start:b.end])
+}
+
+// Snapshot returns a copy of the readable region. Unlike Bytes and To, the
+// copy does not alias the buffer, so it stays valid after further writes or
+// Release and can be shared read-only between goroutines. It returns nil
+// when the buffer is empty.
+func (b *Buffer) Snapshot() []byte {
+	if b.IsEmpty() {
+		return nil
+	}
+	return bytes.Clone(b.data[b.start:b.end])
+}
+
+// Clone returns an independent buffer holding a copy of the readable
+// region, drawn from the alloc pool when its size allows so that Release
+// returns it there. The clone keeps b's compaction threshold, negative
+// policy and maximum capacity; writes to either buffer do not affect the
+// other.
+func (b *Buffer) Clone() *Buffer {
+	c := NewSizeWithAllocator(b.Len(), b.allocator)
+	c.end = copy(c.data, b.data[b.start:b.end])
+	c.compactAt = b.compactAt
+	c.negPolicy = b.negPolicy
+	c.maxCap = b.maxCap
+	return c
+}
+
+// AppendTo appends the readable region to dst and returns the extended
+// slice, without consuming anything.
+func (b *Buffer) AppendTo(dst []byte) []byte {
+	return append(dst, b.data[b.start:b.end]...)
+}
+
+// Reader returns a bytes.Reader over the current readable region without
+// consuming it. The reader aliases the buffer's memory: it sees the bytes
+// readable at the time of the call and is only valid until the buffer is
+// next written to, compacted, reset or released.
+func (b *Buffer) Reader() *bytes.Reader {
+	return bytes.NewReader(b.data[b.start:b.end])
+}
+
 // Len returns the number of readable bytes.
 func (b *Buffer) Len() int {
 	return b.end - b.start
@@ -83,30 +235,124 @@ func (b *Buffer) IsEmpty() bool {
 	return b.Len() == 0
 }
 
+// EqualFold reports whether the readable region equals p under Unicode
+// case-folding. It does not consume anything.
+func (b *Buffer) EqualFold(p []byte) bool {
+	return bytes.EqualFold(b.data[b.start:b.end], p)
+}
+
+// HasPrefixFold reports whether the readable region begins with prefix
+// under Unicode case-folding. It does not consume anything.
+func (b *Buffer) HasPrefixFold(prefix []byte) bool {
+	if b.Len() < len(prefix) {
+		return false
+	}
+	return bytes.EqualFold(b.data[b.start:b.start+len(prefix)], prefix)
+}
+
+// TrimSpace drops leading and trailing ASCII whitespace from the readable
+// region.
+func (b *Buffer) TrimSpace() {
+	b.unread.ok = false
+	for b.start < b.end && isSpace(b.data[b.start]) {
+		b.start++
+	}
+	for b.end > b.start && isSpace(b.data[b.end-1]) {
+		b.end--
+	}
+	if b.start == b.end {
+		b.start = 0
+		b.end = 0
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// IndexByte returns the offset of the first c in the readable region,
+// relative to its start, or -1 if c is not present. It does not consume
+// anything, so the result can be passed to Peek or Discard.
+func (b *Buffer) IndexByte(c byte) int {
+	return bytes.IndexByte(b.data[b.start:b.end], c)
+}
+
+// Index returns the offset of the first occurrence of sub in the readable
+// region, relative to its start, or -1 if sub is not present.
+func (b *Buffer) Index(sub []byte) int {
+	return bytes.Index(b.data[b.start:b.end], sub)
+}
+
 // Reset clears the buffer content but keeps the underlying slice.
 func (b *Buffer) Reset() {
 	b.start = 0
 	b.end = 0
+	b.unread.ok = false
+}
+
+// SetCompactThreshold makes consuming reads move the unread data to the
+// front of the buffer once the read index reaches n, keeping tail space
+// available for streaming writes. n <= 0 disables it (the default).
+func (b *Buffer) SetCompactThreshold(n int) {
+	if n < 0 {
+		n = 0
+	}
+	b.compactAt = n
+}
+
+// Compact moves the unread data to the beginning of the buffer.
+func (b *Buffer) Compact() {
+	if b.start == 0 {
+		return
+	}
+	n := copy(b.data, b.data[b.start:b.end])
+	b.start = 0
+	b.end = n
+}
+
+// advance consumes n readable bytes, resetting the indexes once the buffer
+// is drained and compacting when the threshold is reached.
+func (b *Buffer) advance(n int) {
+	b.consume(n)
+	if b.compactAt > 0 && b.start >= b.compactAt {
+		b.Compact()
+	}
+}
+
+// consume is advance without compaction, for methods that return slices
+// aliasing the consumed bytes.
+func (b *Buffer) consume(n int) {
+	b.unread.ok = false
+	b.start += n
+	if b.start == b.end {
+		// All consumed, reset indexes.
+		b.start = 0
+		b.end = 0
+	}
 }
 
 // grow ensures there is at least n more bytes of free space for writing.
-func (b *Buffer) grow(n int) {
+// It returns ErrBufferFull, changing nothing, if that would take the
+// buffer past its maximum capacity.
+func (b *Buffer) grow(n int) error {
+	b.unread.ok = false // every write goes through here
 	if n <= 0 {
-		return
+		return nil
 	}
 	free := len(b.data) - b.end
 	if free >= n {
-		return
+		return nil
+	}
+	if b.maxCap > 0 && b.Len()+n > b.maxCap {
+		return ErrBufferFull
 	}
 
 	// Try to compact first (move unread data to the beginning).
 	if b.start > 0 && b.Len() > 0 {
-		copy(b.data, b.data[b.start:b.end])
-		b.end = b.Len()
-		b.start = 0
+		b.Compact()
 		free = len(b.data) - b.end
 		if free >= n {
-			return
+			return nil
 		}
 	}
 
@@ -119,60 +365,303 @@ func (b *Buffer) grow(n int) {
 			newCap = n
 		}
 	}
+	if b.maxCap > 0 && newCap > b.maxCap {
+		newCap = b.maxCap
+	}
 
 	newData := make([]byte, newCap)
 	if curLen > 0 {
 		copy(newData, b.data[b.start:b.end])
 	}
+	if b.pooled {
+		// Put rejects slices that are not a pool size; those are dropped.
+		b.putData()
+	}
 	b.data = newData
 	b.start = 0
 	b.end = curLen
 	// The new slice is not from pool.
 	b.pooled = false
+	return nil
+}
+
+// Grow ensures at least n bytes of free space after the readable region,
+// compacting or reallocating as needed, so that the next n bytes of writes
+// do not allocate. Len() is unchanged. It is a no-op for n <= 0, and for a
+// buffer created with NewSizeMax when Len()+n exceeds its maximum capacity.
+func (b *Buffer) Grow(n int) {
+	_ = b.grow(n)
+}
+
+// EnsureCap ensures a capacity of at least total bytes, for when the final
+// size is known up front: unlike Grow it reallocates straight to total (or
+// its pool size class) instead of doubling. Readable content is preserved
+// and moved to the front. For a buffer created with NewSizeMax, total is
+// capped at its maximum capacity.
+func (b *Buffer) EnsureCap(total int) {
+	if b.maxCap > 0 && total > b.maxCap {
+		total = b.maxCap
+	}
+	if total <= len(b.data) {
+		return
+	}
+
+	curLen := b.Len()
+	data, pooled := b.getData(total)
+	if data == nil {
+		data = make([]byte, total)
+	}
+	copy(data, b.data[b.start:b.end])
+	if b.pooled {
+		b.putData()
+	}
+	b.data = data[:total]
+	b.start = 0
+	b.end = curLen
+	b.pooled = pooled
+}
+
+// SetNegativePolicy selects how this buffer handles negative sizes passed
+// to Extend, TryExtend, Truncate, To, Peek, Discard, ReadBytes, ReadSlice,
+// WriteByteN, WritePeekTo and TakeHeader.
+func (b *Buffer) SetNegativePolicy(p NegativePolicy) {
+	b.negPolicy = p
+}
+
+// negative reports ErrNegativeCount for n < 0, panicking instead under
+// NegativePanic, or under NegativeDefault when panicDefault is set.
+func (b *Buffer) negative(n int, panicDefault bool) error {
+	if n >= 0 {
+		return nil
+	}
+	if b.negPolicy == NegativePanic || (b.negPolicy == NegativeDefault && panicDefault) {
+		panic(ErrNegativeCount)
+	}
+	return ErrNegativeCount
 }
 
 // Extend reserves n bytes at the end and returns the slice for caller to fill.
+// A negative n panics unless the policy is NegativeError, in which case it
+// returns nil like To; TryExtend returns the ErrNegativeCount. It also returns nil, reserving nothing, when n bytes do not
+// fit within the maximum capacity of a buffer created with NewSizeMax; use
+// TryExtend to tell the cases apart.
 func (b *Buffer) Extend(n int) []byte {
-	if n < 0 {
-		panic("buffer: negative extend size")
+	p, _ := b.extend(n, true)
+	return p
+}
+
+// TryExtend is Extend reporting a negative n as ErrNegativeCount and a
+// reservation past the maximum capacity as ErrBufferFull. It panics only
+// under NegativePanic.
+func (b *Buffer) TryExtend(n int) ([]byte, error) {
+	return b.extend(n, false)
+}
+
+func (b *Buffer) extend(n int, panicDefault bool) ([]byte, error) {
+	if err := b.negative(n, panicDefault); err != nil {
+		return nil, err
+	}
+	if err := b.grow(n); err != nil {
+		return nil, err
 	}
-	b.grow(n)
 	start := b.end
 	b.end += n
-	return b.data[start:b.end]
+	return b.data[start:b.end], nil
+}
+
+// Truncate keeps the first n readable bytes and discards the rest, e.g. to
+// give back the unused part of a speculative Extend. It panics if n is
+// greater than Len(); a negative n is handled by the NegativePolicy, with
+// the default panicking. Truncate(0) empties the buffer like Reset, keeping
+// the allocation.
+//
+// The storage is not cleared: slices from Extend or Bytes keep their length
+// and still alias the buffer, but bytes past the new end will be
+// overwritten by the next write.
+func (b *Buffer) Truncate(n int) {
+	if b.negative(n, true) != nil {
+		return
+	}
+	if n > b.Len() {
+		panic("buffer: truncation out of range")
+	}
+	b.unread.ok = false
+	b.end = b.start + n
+	if n == 0 {
+		b.start = 0
+		b.end = 0
+	}
 }
 
-// Write appends data to the buffer.
+// Write appends data to the buffer. If p does not fit within the maximum
+// capacity of a buffer created with NewSizeMax, the part that fits is
+// written and ErrBufferFull returned with its length.
 func (b *Buffer) Write(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
-	b.grow(len(p))
+	var err error
+	if b.grow(len(p)) != nil {
+		p = p[:b.maxCap-b.Len()]
+		_ = b.grow(len(p))
+		err = ErrBufferFull
+	}
 	n := copy(b.data[b.end:], p)
 	b.end += n
-	return n, nil
+	return n, err
 }
 
 // WriteByte appends a single byte to the buffer.
 func (b *Buffer) WriteByte(c byte) error {
-	b.grow(1)
+	if err := b.grow(1); err != nil {
+		return err
+	}
 	b.data[b.end] = c
 	b.end++
 	return nil
 }
 
+// WriteByteN appends n copies of c, e.g. for padding.
+func (b *Buffer) WriteByteN(c byte, n int) error {
+	if err := b.negative(n, false); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	if err := b.grow(n); err != nil {
+		return err
+	}
+	p := b.data[b.end : b.end+n]
+	for i := range p {
+		p[i] = c
+	}
+	b.end += n
+	return nil
+}
+
+// InsertAt inserts p at offset off of the readable region, shifting the
+// bytes after off to the right and growing the buffer as needed. It panics
+// if off is outside [0, Len()], and returns ErrBufferFull if p does not fit
+// within a NewSizeMax limit.
+func (b *Buffer) InsertAt(off int, p []byte) error {
+	if off < 0 || off > b.Len() {
+		panic("buffer: insert offset out of range")
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	if err := b.grow(len(p)); err != nil {
+		return err
+	}
+	at := b.start + off
+	copy(b.data[at+len(p):], b.data[at:b.end])
+	copy(b.data[at:], p)
+	b.end += len(p)
+	return nil
+}
+
+// Prepend inserts p in front of the readable region, e.g. a length header
+// computed after the payload was written. When the space before the read
+// index can hold p it is copied there without moving the payload;
+// otherwise it behaves like InsertAt(0, p).
+func (b *Buffer) Prepend(p []byte) error {
+	if b.start < len(p) {
+		return b.InsertAt(0, p)
+	}
+	b.unread.ok = false
+	b.start -= len(p)
+	copy(b.data[b.start:], p)
+	return nil
+}
+
+// WriteUvarintPrefixed appends the body produced by fn, preceded by its
+// length as a uvarint. The body is written first and the prefix, whose
+// width depends on the body length, is inserted in front of it afterwards.
+// If fn returns an error, or the prefix does not fit within the maximum
+// capacity, everything it wrote is discarded.
+func (b *Buffer) WriteUvarintPrefixed(fn func(*Buffer) error) error {
+	off := b.Len()
+	if err := fn(b); err != nil {
+		b.end = b.start + off
+		return err
+	}
+
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(b.Len()-off))
+	if err := b.InsertAt(off, prefix[:n]); err != nil {
+		b.end = b.start + off
+		return err
+	}
+	return nil
+}
+
+// minRead is the smallest free space ReadFrom offers to a Read call.
+const minRead = 512
+
+// ReadFrom reads from r until io.EOF, appending the data to the buffer,
+// and returns the number of bytes read. As with bytes.Buffer, io.EOF is not
+// reported; any other error is returned along with the count read before
+// it. The buffer grows geometrically while it fills up; a buffer created
+// with NewSizeMax is filled up to its limit and then ErrBufferFull returned.
+func (b *Buffer) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		if len(b.data)-b.end < minRead {
+			if b.grow(minRead) != nil {
+				// take whatever room is left below the limit
+				_ = b.grow(b.maxCap - b.Len())
+				if len(b.data) == b.end {
+					return total, ErrBufferFull
+				}
+			}
+		}
+		p := b.Extend(len(b.data) - b.end)
+		n, err := r.Read(p)
+		if n < 0 || n > len(p) {
+			panic("buffer: reader returned invalid count from Read")
+		}
+		b.end -= len(p) - n
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// WriteTo writes the readable region to w, consuming what was written,
+// until the buffer is drained or w fails. A write that makes no progress
+// without an error is reported as io.ErrShortWrite. On error the unwritten
+// bytes stay readable, so the call can be retried.
+func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for !b.IsEmpty() {
+		n, err := w.Write(b.data[b.start:b.end])
+		if n < 0 || n > b.Len() {
+			panic("buffer: writer returned invalid count from Write")
+		}
+		b.advance(n)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+		if n == 0 {
+			return total, io.ErrShortWrite
+		}
+	}
+	return total, nil
+}
+
 // Read reads from the buffer into p.
 func (b *Buffer) Read(p []byte) (int, error) {
 	if b.IsEmpty() {
 		return 0, io.EOF
 	}
 	n := copy(p, b.data[b.start:b.end])
-	b.start += n
-	if b.start == b.end {
-		// All consumed, reset indexes.
-		b.start = 0
-		b.end = 0
-	}
+	b.advance(n)
 	return n, nil
 }
 
@@ -182,18 +671,36 @@ func (b *Buffer) ReadByte() (byte, error) {
 		return 0, io.EOF
 	}
 	c := b.data[b.start]
-	b.start++
-	if b.start == b.end {
-		b.start = 0
-		b.end = 0
-	}
+	b.advance(1)
+	b.unread = unreadState{ok: true, c: c, start: b.start, end: b.end}
 	return c, nil
 }
 
-// To returns the first n bytes of the readable region.
-// If n > Len(), it clamps to Len().
+// UnreadByte puts back the byte returned by the last ReadByte. It returns
+// ErrUnreadByte unless it immediately follows a successful ReadByte; a
+// ReadByte that drained the buffer and reset its indexes can still be
+// undone, leaving the byte as the only readable data.
+func (b *Buffer) UnreadByte() error {
+	u := b.unread
+	b.unread.ok = false
+	if !u.ok || b.start != u.start || b.end != u.end {
+		return ErrUnreadByte
+	}
+	if b.start > 0 {
+		b.start--
+		b.data[b.start] = u.c
+		return nil
+	}
+	// the indexes were reset or the data compacted to the front; the byte
+	// was readable a moment ago, so it fits
+	_ = b.InsertAt(0, []byte{u.c})
+	return nil
+}
+
+// To returns the first n bytes of the readable region without consuming
+// them. If n > Len(), it clamps to Len(); use Peek to require exactly n.
 func (b *Buffer) To(n int) []byte {
-	if n <= 0 {
+	if b.negative(n, false) != nil || n == 0 {
 		return nil
 	}
 	if n > b.Len() {
@@ -202,32 +709,360 @@ func (b *Buffer) To(n int) []byte {
 	return b.data[b.start : b.start+n]
 }
 
+// Peek returns the next n readable bytes without consuming them, e.g. to
+// inspect a length prefix before deciding to consume a frame. If fewer than
+// n bytes are buffered it returns ErrIncomplete instead of clamping like To.
+//
+// The slice aliases the buffer's storage: it is valid until the next write,
+// compaction, Reset or Release, and changes to it change the buffer.
+func (b *Buffer) Peek(n int) ([]byte, error) {
+	if err := b.negative(n, false); err != nil {
+		return nil, err
+	}
+	if b.Len() < n {
+		return nil, ErrIncomplete
+	}
+	return b.data[b.start : b.start+n], nil
+}
+
+// ReadSlice is ReadBytes without the copy: it consumes the next n bytes
+// and returns them as a slice of the buffer's storage, or io.EOF without
+// consuming anything if fewer are readable. The slice is only valid until
+// the next write, Grow, Compact, Reset or Release, which may overwrite or
+// free it; callers must not retain it, and should use ReadBytes when they
+// need to.
+func (b *Buffer) ReadSlice(n int) ([]byte, error) {
+	if err := b.negative(n, false); err != nil {
+		return nil, err
+	}
+	if b.Len() < n {
+		return nil, io.EOF
+	}
+	p := b.data[b.start : b.start+n]
+	b.consume(n)
+	return p, nil
+}
+
+// Discard skips the next n readable bytes without copying them and returns
+// the number skipped. If fewer than n bytes are buffered, it skips them all
+// and returns io.EOF.
+func (b *Buffer) Discard(n int) (int, error) {
+	if err := b.negative(n, false); err != nil {
+		return 0, err
+	}
+	var err error
+	if n > b.Len() {
+		n = b.Len()
+		err = io.EOF
+	}
+	b.advance(n)
+	return n, err
+}
+
 // ReadBytes returns exactly n bytes (or error if not enough).
 func (b *Buffer) ReadBytes(n int) ([]byte, error) {
-	if n < 0 {
-		return nil, errors.New("buffer: negative length")
+	if err := b.negative(n, false); err != nil {
+		return nil, err
 	}
 	if b.Len() < n {
 		return nil, io.EOF
 	}
 	out := make([]byte, n)
 	copy(out, b.data[b.start:b.start+n])
-	b.start += n
-	if b.start == b.end {
-		b.start = 0
-		b.end = 0
-	}
+	b.advance(n)
 	return out, nil
 }
 
+// ExpectPrefix consumes magic if the readable region starts with it.
+// It returns ErrPrefixMismatch if the available bytes differ from magic and
+// ErrIncomplete if they match so far but are too few; in both cases nothing
+// is consumed.
+func (b *Buffer) ExpectPrefix(magic []byte) error {
+	avail := b.data[b.start:b.end]
+	if len(avail) > len(magic) {
+		avail = avail[:len(magic)]
+	}
+	if !bytes.Equal(avail, magic[:len(avail)]) {
+		return ErrPrefixMismatch
+	}
+	if len(avail) < len(magic) {
+		return ErrIncomplete
+	}
+	b.advance(len(magic))
+	return nil
+}
+
+// WritevTo writes the readable regions of bufs to w in order without
+// concatenating them. When w is a net.Conn this becomes a single writev
+// call. Each buffer is advanced by the number of its bytes that were
+// written, so after an error the remaining data can be retried.
+func WritevTo(w io.Writer, bufs ...*Buffer) (int64, error) {
+	vec := make(net.Buffers, 0, len(bufs))
+	for _, b := range bufs {
+		if !b.IsEmpty() {
+			vec = append(vec, b.Bytes())
+		}
+	}
+	n, err := vec.WriteTo(w)
+
+	rest := n
+	for _, b := range bufs {
+		if rest == 0 {
+			break
+		}
+		k := min(int64(b.Len()), rest)
+		b.advance(int(k))
+		rest -= k
+	}
+	return n, err
+}
+
+// TakeHeader returns the first size readable bytes and consumes them if
+// they are all available (ok=true). Otherwise the buffer is left untouched
+// (ok=false) so the caller can wait for more data.
+//
+// The header aliases the buffer's storage and must be consumed before the
+// next write.
+func (b *Buffer) TakeHeader(size int) (header []byte, ok bool) {
+	if b.negative(size, false) != nil || b.Len() < size {
+		return nil, false
+	}
+	header = b.data[b.start : b.start+size]
+	b.consume(size)
+	return header, true
+}
+
+// ReadFields consumes the next line (up to and including '\n', or the rest
+// of the data if there is no newline) and splits it on sep. At most max
+// fields are returned, the last one holding the unsplit remainder; max <= 0
+// returns all fields. A trailing "\r" is dropped, empty fields are kept.
+// It returns io.EOF if the buffer is empty.
+//
+// The fields alias the buffer's storage and are only valid until the next
+// write.
+func (b *Buffer) ReadFields(sep byte, max int) ([][]byte, error) {
+	if b.IsEmpty() {
+		return nil, io.EOF
+	}
+	line := b.data[b.start:b.end]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i+1]
+	}
+	b.consume(len(line))
+
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if max <= 0 {
+		max = -1
+	}
+	return bytes.SplitN(line, []byte{sep}, max), nil
+}
+
+// ReadString consumes and returns the data up to and including the first
+// occurrence of delim. If delim is not found it consumes and returns all
+// remaining data along with io.EOF, as bytes.Buffer.ReadString does.
+func (b *Buffer) ReadString(delim byte) (string, error) {
+	data := b.data[b.start:b.end]
+	var err error
+	if i := bytes.IndexByte(data, delim); i >= 0 {
+		data = data[:i+1]
+	} else {
+		err = io.EOF
+	}
+	s := string(data)
+	b.advance(len(data))
+	return s, err
+}
+
+// ConsumeLine returns the next complete line, without its '\n', and
+// consumes it including the newline. If no complete line is buffered yet it
+// returns ok=false and leaves the buffer untouched, so it can be called in
+// a loop as data arrives. The line aliases the buffer and is only valid
+// until the next write.
+func (b *Buffer) ConsumeLine() (line []byte, ok bool) {
+	i := bytes.IndexByte(b.data[b.start:b.end], '\n')
+	if i < 0 {
+		return nil, false
+	}
+	line = b.data[b.start : b.start+i]
+	b.consume(i + 1)
+	return line, true
+}
+
+// WriteUvarint appends v in unsigned varint encoding. On a buffer created
+// with NewSizeMax it returns ErrBufferFull, writing nothing, if the encoding
+// does not fit; otherwise it always returns nil.
+func (b *Buffer) WriteUvarint(v uint64) error {
+	var tmp [binary.MaxVarintLen64]byte
+	return b.writeAll(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+// WriteVarint appends v in zig-zag signed varint encoding, with the same
+// limit behavior as WriteUvarint.
+func (b *Buffer) WriteVarint(v int64) error {
+	var tmp [binary.MaxVarintLen64]byte
+	return b.writeAll(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+// writeAll appends p if it fits entirely, or returns ErrBufferFull.
+func (b *Buffer) writeAll(p []byte) error {
+	if err := b.grow(len(p)); err != nil {
+		return err
+	}
+	b.end += copy(b.data[b.end:], p)
+	return nil
+}
+
+// ReadUvarint decodes and consumes an unsigned varint. It returns
+// ErrIncomplete without consuming anything if the varint is not fully
+// buffered yet, and ErrVarintOverflow if it exceeds 64 bits.
+func (b *Buffer) ReadUvarint() (uint64, error) {
+	v, n := binary.Uvarint(b.data[b.start:b.end])
+	if n == 0 {
+		return 0, ErrIncomplete
+	}
+	if n < 0 {
+		return 0, ErrVarintOverflow
+	}
+	b.advance(n)
+	return v, nil
+}
+
+// ReadVarint decodes and consumes a signed varint, with the same error
+// semantics as ReadUvarint.
+func (b *Buffer) ReadVarint() (int64, error) {
+	v, n := binary.Varint(b.data[b.start:b.end])
+	if n == 0 {
+		return 0, ErrIncomplete
+	}
+	if n < 0 {
+		return 0, ErrVarintOverflow
+	}
+	b.advance(n)
+	return v, nil
+}
+
+// PutUint16 appends v in the given byte order. Like WriteUvarint, it
+// returns ErrBufferFull, writing nothing, if v does not fit within a
+// NewSizeMax limit.
+func (b *Buffer) PutUint16(v uint16, order binary.ByteOrder) error {
+	p, err := b.extend(2, false)
+	if err != nil {
+		return err
+	}
+	order.PutUint16(p, v)
+	return nil
+}
+
+// PutUint32 appends v in the given byte order.
+func (b *Buffer) PutUint32(v uint32, order binary.ByteOrder) error {
+	p, err := b.extend(4, false)
+	if err != nil {
+		return err
+	}
+	order.PutUint32(p, v)
+	return nil
+}
+
+// PutUint64 appends v in the given byte order.
+func (b *Buffer) PutUint64(v uint64, order binary.ByteOrder) error {
+	p, err := b.extend(8, false)
+	if err != nil {
+		return err
+	}
+	order.PutUint64(p, v)
+	return nil
+}
+
+// ReadUint16 consumes a uint16 in the given byte order. It returns io.EOF,
+// consuming nothing, if fewer than 2 bytes are readable.
+func (b *Buffer) ReadUint16(order binary.ByteOrder) (uint16, error) {
+	if b.Len() < 2 {
+		return 0, io.EOF
+	}
+	v := order.Uint16(b.data[b.start:])
+	b.advance(2)
+	return v, nil
+}
+
+// ReadUint32 consumes a uint32 in the given byte order, as ReadUint16.
+func (b *Buffer) ReadUint32(order binary.ByteOrder) (uint32, error) {
+	if b.Len() < 4 {
+		return 0, io.EOF
+	}
+	v := order.Uint32(b.data[b.start:])
+	b.advance(4)
+	return v, nil
+}
+
+// ReadUint64 consumes a uint64 in the given byte order, as ReadUint16.
+func (b *Buffer) ReadUint64(order binary.ByteOrder) (uint64, error) {
+	if b.Len() < 8 {
+		return 0, io.EOF
+	}
+	v := order.Uint64(b.data[b.start:])
+	b.advance(8)
+	return v, nil
+}
+
 // Release returns the underlying slice to the alloc pool if it came from there,
-// and resets the Buffer to zero value.
+// and empties the Buffer. Its configuration (allocator, maximum capacity,
+// compaction threshold and negative policy) is kept, so a released buffer
+// can be reused with the same limits.
 func (b *Buffer) Release() {
 	if b == nil {
 		return
 	}
 	if b.pooled && b.data != nil {
-		_ = alloc.Put(b.data)
+		b.putData()
+	}
+	*b = Buffer{
+		allocator: b.allocator,
+		compactAt: b.compactAt,
+		negPolicy: b.negPolicy,
+		maxCap:    b.maxCap,
+	}
+}
+
+// getData returns a slice of length size from the buffer's allocator, or
+// nil if the allocator does not serve that size. pooled reports whether
+// the slice belongs to a size class; an allocator from
+// alloc.NewAllocatorRange serves sizes below its minimum with make.
+func (b *Buffer) getData(size int) (data []byte, pooled bool) {
+	if b.allocator != nil {
+		_, _, ok := b.allocator.ClassOf(size)
+		return b.allocator.Get(size), ok
+	}
+	data = alloc.Get(size)
+	return data, data != nil
+}
+
+// putData returns b.data to the allocator it came from.
+func (b *Buffer) putData() {
+	if b.allocator != nil {
+		_ = b.allocator.Put(b.data)
+		return
+	}
+	_ = alloc.Put(b.data)
+}
+
+// WritePeekTo writes up to max readable bytes to w without consuming them.
+// If max exceeds Len(), only Len() bytes are written. It is meant for
+// mirroring data to a secondary sink while the buffer is still parsed.
+// A negative max is handled by the NegativePolicy.
+func (b *Buffer) WritePeekTo(w io.Writer, max int) (int, error) {
+	if err := b.negative(max, false); err != nil {
+		return 0, err
+	}
+	if max == 0 || b.IsEmpty() {
+		return 0, nil
+	}
+	if max > b.Len() {
+		max = b.Len()
+	}
+	n, err := w.Write(b.data[b.start : b.start+max])
+	if err == nil && n < max {
+		err = io.ErrShortWrite
 	}
-	*b = Buffer{}
+	return n, err
 }
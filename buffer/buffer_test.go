@@ -2,8 +2,14 @@ package buffer
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"net"
+	"strconv"
 	"testing"
+
+	"github.com/ninepeach/ark/alloc"
 )
 
 func TestNewSizeAndBasicProps(t *testing.T) {
@@ -209,3 +215,1464 @@ func TestReleaseNoPanic(t *testing.T) {
 	b1.Release()
 	b2.Release()
 }
+
+func TestReleaseKeepsConfig(t *testing.T) {
+	b := NewSizeMax(8, 8)
+	b.SetCompactThreshold(4)
+	b.SetNegativePolicy(NegativeError)
+	b.Release()
+
+	if n, err := b.Write(make([]byte, 100)); n != 8 || err != ErrBufferFull {
+		t.Fatalf("Write after Release n=%d err=%v, want 8 and ErrBufferFull", n, err)
+	}
+	if b.compactAt != 4 || b.negPolicy != NegativeError {
+		t.Fatalf("compactAt=%d negPolicy=%v after Release", b.compactAt, b.negPolicy)
+	}
+}
+
+type errWriter struct{ n int }
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return w.n, io.ErrClosedPipe
+	}
+	return len(p), nil
+}
+
+func TestWritePeekTo(t *testing.T) {
+	b := FromBytes([]byte("hello world"))
+
+	var out bytes.Buffer
+	n, err := b.WritePeekTo(&out, 5)
+	if err != nil {
+		t.Fatalf("WritePeekTo error: %v", err)
+	}
+	if n != 5 || out.String() != "hello" {
+		t.Fatalf("WritePeekTo n=%d out=%q, want 5 %q", n, out.String(), "hello")
+	}
+	if b.Len() != 11 {
+		t.Fatalf("WritePeekTo consumed data: Len=%d, want=11", b.Len())
+	}
+
+	out.Reset()
+	n, err = b.WritePeekTo(&out, 100)
+	if err != nil {
+		t.Fatalf("WritePeekTo(100) error: %v", err)
+	}
+	if n != 11 || out.String() != "hello world" {
+		t.Fatalf("WritePeekTo(100) n=%d out=%q", n, out.String())
+	}
+
+	n, err = b.WritePeekTo(&errWriter{n: 3}, 8)
+	if err != io.ErrClosedPipe {
+		t.Fatalf("expected writer error, got %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("WritePeekTo partial n=%d, want=3", n)
+	}
+	if b.Len() != 11 {
+		t.Fatalf("WritePeekTo consumed data on error: Len=%d", b.Len())
+	}
+}
+
+func TestCompactThreshold(t *testing.T) {
+	b := NewSize(16)
+	b.SetCompactThreshold(8)
+	if _, err := b.Write([]byte("0123456789abcdef")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	p := make([]byte, 6)
+	if _, err := b.Read(p); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	// below threshold: no compaction yet
+	if b.start != 6 {
+		t.Fatalf("start=%d, want=6 before threshold", b.start)
+	}
+
+	if _, err := b.Read(p[:2]); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if b.start != 0 || b.Len() != 8 {
+		t.Fatalf("expected compaction at threshold: start=%d Len=%d", b.start, b.Len())
+	}
+	if string(b.Bytes()) != "89abcdef" {
+		t.Fatalf("Bytes after compaction=%q, want %q", string(b.Bytes()), "89abcdef")
+	}
+
+	// disabled by default
+	b2 := FromBytes([]byte("0123456789"))
+	if _, err := b2.ReadBytes(9); err != nil {
+		t.Fatalf("ReadBytes error: %v", err)
+	}
+	if b2.start != 9 {
+		t.Fatalf("unexpected compaction with threshold off: start=%d", b2.start)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	b := FromBytes([]byte("hello"))
+	if _, err := b.ReadByte(); err != nil {
+		t.Fatalf("ReadByte error: %v", err)
+	}
+	b.Compact()
+	if b.start != 0 || string(b.Bytes()) != "ello" {
+		t.Fatalf("Compact: start=%d Bytes=%q", b.start, string(b.Bytes()))
+	}
+}
+
+func TestEqualFold(t *testing.T) {
+	b := FromBytes([]byte("Content-Length"))
+	if !b.EqualFold([]byte("content-length")) {
+		t.Fatal("EqualFold should match ignoring case")
+	}
+	if !b.EqualFold([]byte("CONTENT-LENGTH")) {
+		t.Fatal("EqualFold should match upper case")
+	}
+	if b.EqualFold([]byte("content-type")) {
+		t.Fatal("EqualFold should not match a different header")
+	}
+	if b.Len() != 14 {
+		t.Fatalf("EqualFold consumed data: Len=%d", b.Len())
+	}
+}
+
+func TestHasPrefixFold(t *testing.T) {
+	b := FromBytes([]byte("HOST: example.com"))
+	if !b.HasPrefixFold([]byte("host:")) {
+		t.Fatal("HasPrefixFold should match mixed case prefix")
+	}
+	if b.HasPrefixFold([]byte("hostname")) {
+		t.Fatal("HasPrefixFold should not match")
+	}
+	if FromBytes([]byte("Ho")).HasPrefixFold([]byte("host")) {
+		t.Fatal("HasPrefixFold should not match a prefix longer than the data")
+	}
+	if !b.HasPrefixFold(nil) {
+		t.Fatal("HasPrefixFold(nil) should be true")
+	}
+}
+
+func TestTrimSpace(t *testing.T) {
+	b := FromBytes([]byte(" \t value \r\n"))
+	b.TrimSpace()
+	if string(b.Bytes()) != "value" {
+		t.Fatalf("TrimSpace=%q, want %q", string(b.Bytes()), "value")
+	}
+
+	b = FromBytes([]byte(" \r\n"))
+	b.TrimSpace()
+	if !b.IsEmpty() {
+		t.Fatalf("TrimSpace of blanks should leave empty buffer, got %q", string(b.Bytes()))
+	}
+}
+
+func TestWriteUvarintPrefixed(t *testing.T) {
+	b := NewSize(4)
+	if _, err := b.Write([]byte("hdr")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	body := bytes.Repeat([]byte("x"), 300) // needs a 2-byte uvarint
+	err := b.WriteUvarintPrefixed(func(w *Buffer) error {
+		_, err := w.Write(body)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WriteUvarintPrefixed error: %v", err)
+	}
+
+	want := append([]byte("hdr"), binary.AppendUvarint(nil, 300)...)
+	want = append(want, body...)
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Fatalf("prefixed body mismatch: got %d bytes, want %d", b.Len(), len(want))
+	}
+
+	// failing body is rolled back
+	err = b.WriteUvarintPrefixed(func(w *Buffer) error {
+		_, _ = w.Write([]byte("partial"))
+		return io.ErrUnexpectedEOF
+	})
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected fn error, got %v", err)
+	}
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Fatalf("failed body was not discarded: Len=%d", b.Len())
+	}
+
+	// empty body gets a single zero prefix
+	b2 := NewSize(0)
+	if err := b2.WriteUvarintPrefixed(func(*Buffer) error { return nil }); err != nil {
+		t.Fatalf("empty body error: %v", err)
+	}
+	if !bytes.Equal(b2.Bytes(), []byte{0}) {
+		t.Fatalf("empty body Bytes=%v, want [0]", b2.Bytes())
+	}
+}
+
+func TestWriteByteN(t *testing.T) {
+	b := NewSize(2)
+	if _, err := b.Write([]byte("ab")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	if err := b.WriteByteN('-', 5); err != nil {
+		t.Fatalf("WriteByteN error: %v", err)
+	}
+	if string(b.Bytes()) != "ab-----" {
+		t.Fatalf("Bytes=%q, want %q", string(b.Bytes()), "ab-----")
+	}
+	if err := b.WriteByteN('x', 0); err != nil {
+		t.Fatalf("WriteByteN(0) error: %v", err)
+	}
+	if err := b.WriteByteN('x', -1); err == nil {
+		t.Fatal("WriteByteN(-1) should return error")
+	}
+	if b.Len() != 7 {
+		t.Fatalf("Len=%d, want=7", b.Len())
+	}
+}
+
+func TestExpectPrefix(t *testing.T) {
+	magic := []byte{0xCA, 0xFE}
+
+	// match
+	b := FromBytes([]byte{0xCA, 0xFE, 0x01})
+	if err := b.ExpectPrefix(magic); err != nil {
+		t.Fatalf("ExpectPrefix match error: %v", err)
+	}
+	if !bytes.Equal(b.Bytes(), []byte{0x01}) {
+		t.Fatalf("ExpectPrefix did not consume magic: %v", b.Bytes())
+	}
+
+	// mismatch
+	b = FromBytes([]byte{0xCA, 0xFF, 0x01})
+	if err := b.ExpectPrefix(magic); err != ErrPrefixMismatch {
+		t.Fatalf("expected ErrPrefixMismatch, got %v", err)
+	}
+	if b.Len() != 3 {
+		t.Fatalf("mismatch consumed data: Len=%d", b.Len())
+	}
+
+	// short buffer, matching so far
+	b = FromBytes([]byte{0xCA})
+	if err := b.ExpectPrefix(magic); err != ErrIncomplete {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+	if b.Len() != 1 {
+		t.Fatalf("short buffer consumed data: Len=%d", b.Len())
+	}
+
+	// short buffer, already mismatching
+	b = FromBytes([]byte{0x00})
+	if err := b.ExpectPrefix(magic); err != ErrPrefixMismatch {
+		t.Fatalf("expected ErrPrefixMismatch on short mismatch, got %v", err)
+	}
+
+	// empty buffer
+	if err := NewSize(0).ExpectPrefix(magic); err != ErrIncomplete {
+		t.Fatalf("expected ErrIncomplete on empty buffer, got %v", err)
+	}
+}
+
+func TestWritevTo(t *testing.T) {
+	hdr := FromBytes([]byte("HDR:"))
+	empty := NewSize(0)
+	body := FromBytes([]byte("payload"))
+
+	var out bytes.Buffer
+	n, err := WritevTo(&out, hdr, empty, body)
+	if err != nil {
+		t.Fatalf("WritevTo error: %v", err)
+	}
+	if n != 11 || out.String() != "HDR:payload" {
+		t.Fatalf("WritevTo n=%d out=%q", n, out.String())
+	}
+	if !hdr.IsEmpty() || !body.IsEmpty() {
+		t.Fatalf("buffers not consumed: hdr=%d body=%d", hdr.Len(), body.Len())
+	}
+}
+
+func TestWritevToPartial(t *testing.T) {
+	hdr := FromBytes([]byte("HDR:"))
+	body := FromBytes([]byte("payload"))
+
+	// errWriter accepts hdr whole, then only 6 of the 7 body bytes
+	n, err := WritevTo(&errWriter{n: 6}, hdr, body)
+	if err != io.ErrClosedPipe {
+		t.Fatalf("expected writer error, got %v", err)
+	}
+	if n != 10 {
+		t.Fatalf("WritevTo n=%d, want=10", n)
+	}
+	if !hdr.IsEmpty() || string(body.Bytes()) != "d" {
+		t.Fatalf("cursor not advanced by n: hdr=%q body=%q", hdr.Bytes(), body.Bytes())
+	}
+
+	// retry sends exactly what is left
+	var out bytes.Buffer
+	if _, err := WritevTo(&out, hdr, body); err != nil {
+		t.Fatalf("retry error: %v", err)
+	}
+	if got := string("HDR:payload"[n:]); out.String() != got {
+		t.Fatalf("retry out=%q, want %q", out.String(), got)
+	}
+}
+
+func TestWritevToConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	done := make(chan []byte, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			done <- nil
+			return
+		}
+		defer c.Close()
+		data, _ := io.ReadAll(c)
+		done <- data
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if _, err := WritevTo(conn, FromBytes([]byte("ab")), FromBytes([]byte("cd"))); err != nil {
+		t.Fatalf("WritevTo conn error: %v", err)
+	}
+	conn.Close()
+
+	if got := <-done; string(got) != "abcd" {
+		t.Fatalf("received %q, want %q", got, "abcd")
+	}
+}
+
+func TestVarintRoundTrip(t *testing.T) {
+	b := NewSize(0)
+	uvals := []uint64{0, 1, 127, 128, 300, 1<<63 + 5}
+	ivals := []int64{0, -1, 63, -64, 1 << 40, -1 << 62}
+	for _, v := range uvals {
+		b.WriteUvarint(v)
+	}
+	for _, v := range ivals {
+		b.WriteVarint(v)
+	}
+
+	for _, want := range uvals {
+		got, err := b.ReadUvarint()
+		if err != nil || got != want {
+			t.Fatalf("ReadUvarint=(%d, %v), want %d", got, err, want)
+		}
+	}
+	for _, want := range ivals {
+		got, err := b.ReadVarint()
+		if err != nil || got != want {
+			t.Fatalf("ReadVarint=(%d, %v), want %d", got, err, want)
+		}
+	}
+	if !b.IsEmpty() {
+		t.Fatalf("expected empty buffer, Len=%d", b.Len())
+	}
+}
+
+func TestReadUvarintIncomplete(t *testing.T) {
+	full := binary.AppendUvarint(nil, 1<<20)
+	b := NewSize(0)
+
+	// feed one byte at a time; only the last one completes the varint
+	for i, c := range full {
+		_ = b.WriteByte(c)
+		v, err := b.ReadUvarint()
+		if i < len(full)-1 {
+			if err != ErrIncomplete {
+				t.Fatalf("byte %d: expected ErrIncomplete, got %v", i, err)
+			}
+			if b.Len() != i+1 {
+				t.Fatalf("byte %d: incomplete read consumed data", i)
+			}
+			continue
+		}
+		if err != nil || v != 1<<20 {
+			t.Fatalf("ReadUvarint=(%d, %v), want %d", v, err, 1<<20)
+		}
+	}
+
+	if _, err := NewSize(0).ReadVarint(); err != ErrIncomplete {
+		t.Fatalf("ReadVarint on empty buffer: expected ErrIncomplete, got %v", err)
+	}
+}
+
+func TestReadUvarintOverflow(t *testing.T) {
+	b := FromBytes(bytes.Repeat([]byte{0xff}, 11))
+	if _, err := b.ReadUvarint(); err != ErrVarintOverflow {
+		t.Fatalf("expected ErrVarintOverflow, got %v", err)
+	}
+	if b.Len() != 11 {
+		t.Fatalf("overflow consumed data: Len=%d", b.Len())
+	}
+}
+
+func TestReadFields(t *testing.T) {
+	b := FromBytes([]byte("a,b,,c\r\nx,y,z,w\nlast,"))
+
+	fields, err := b.ReadFields(',', 0)
+	if err != nil {
+		t.Fatalf("ReadFields error: %v", err)
+	}
+	if got := string(bytes.Join(fields, []byte("|"))); got != "a|b||c" || len(fields) != 4 {
+		t.Fatalf("fields=%q, want a|b||c", got)
+	}
+
+	fields, err = b.ReadFields(',', 2)
+	if err != nil {
+		t.Fatalf("ReadFields(max=2) error: %v", err)
+	}
+	if len(fields) != 2 || string(fields[0]) != "x" || string(fields[1]) != "y,z,w" {
+		t.Fatalf("fields=%q, want [x y,z,w]", fields)
+	}
+
+	// no trailing newline, trailing separator yields an empty last field
+	fields, err = b.ReadFields(',', 0)
+	if err != nil {
+		t.Fatalf("ReadFields(last) error: %v", err)
+	}
+	if len(fields) != 2 || string(fields[0]) != "last" || len(fields[1]) != 0 {
+		t.Fatalf("fields=%q, want [last \"\"]", fields)
+	}
+
+	if _, err := b.ReadFields(',', 0); err != io.EOF {
+		t.Fatalf("expected EOF on empty buffer, got %v", err)
+	}
+}
+
+func TestReadFieldsNoCompaction(t *testing.T) {
+	b := NewSize(32)
+	b.SetCompactThreshold(1)
+	_, _ = b.Write([]byte("k,v\nmore"))
+
+	fields, err := b.ReadFields(',', 0)
+	if err != nil {
+		t.Fatalf("ReadFields error: %v", err)
+	}
+	// fields must still be intact after consuming past the threshold
+	if string(fields[0]) != "k" || string(fields[1]) != "v" {
+		t.Fatalf("fields corrupted: %q", fields)
+	}
+}
+
+func TestAppendTo(t *testing.T) {
+	b := FromBytes([]byte("world"))
+	dst := []byte("hello ")
+
+	out := b.AppendTo(dst)
+	if string(out) != "hello world" {
+		t.Fatalf("AppendTo=%q, want %q", string(out), "hello world")
+	}
+	if b.Len() != 5 {
+		t.Fatalf("AppendTo consumed data: Len=%d", b.Len())
+	}
+
+	// the result does not alias the buffer
+	out[6] = 'W'
+	if string(b.Bytes()) != "world" {
+		t.Fatalf("AppendTo result aliases buffer: %q", string(b.Bytes()))
+	}
+
+	if got := NewSize(0).AppendTo(nil); len(got) != 0 {
+		t.Fatalf("AppendTo on empty buffer=%q", got)
+	}
+}
+
+func TestTakeHeader(t *testing.T) {
+	b := NewSize(16)
+	_, _ = b.Write([]byte{0x00, 0x03, 'a'})
+
+	// body length is known, but not enough data for a 4-byte header yet
+	if h, ok := b.TakeHeader(4); ok || h != nil {
+		t.Fatalf("TakeHeader(4) on 3 bytes = (%v, %v), want (nil, false)", h, ok)
+	}
+	if b.Len() != 3 {
+		t.Fatalf("failed TakeHeader consumed data: Len=%d", b.Len())
+	}
+
+	h, ok := b.TakeHeader(2)
+	if !ok || !bytes.Equal(h, []byte{0x00, 0x03}) {
+		t.Fatalf("TakeHeader(2) = (%v, %v)", h, ok)
+	}
+	if string(b.Bytes()) != "a" {
+		t.Fatalf("remaining=%q, want %q", string(b.Bytes()), "a")
+	}
+
+	if _, ok := b.TakeHeader(-1); ok {
+		t.Fatal("TakeHeader(-1) should fail")
+	}
+	if h, ok := b.TakeHeader(0); !ok || len(h) != 0 {
+		t.Fatalf("TakeHeader(0) = (%v, %v), want empty ok", h, ok)
+	}
+}
+
+func TestReader(t *testing.T) {
+	b := NewSize(16)
+	_, _ = b.Write([]byte("hello"))
+
+	r := b.Reader()
+	got, err := io.ReadAll(r)
+	if err != nil || string(got) != "hello" {
+		t.Fatalf("ReadAll=%q err=%v, want %q", got, err, "hello")
+	}
+	if b.Len() != 5 {
+		t.Fatalf("Reader consumed the buffer: Len=%d", b.Len())
+	}
+
+	// the reader is independent of the buffer's read index
+	_, _ = b.ReadByte()
+	if r2 := b.Reader(); r2.Len() != 4 {
+		t.Fatalf("Reader after ReadByte: Len=%d, want 4", r2.Len())
+	}
+	if data, _ := io.ReadAll(b); string(data) != "ello" {
+		t.Fatalf("buffer read=%q, want %q", data, "ello")
+	}
+}
+
+func TestSetDefaultSize(t *testing.T) {
+	defer SetDefaultSize(DefaultSize)
+
+	old := New()
+	if err := SetDefaultSize(4096); err != nil {
+		t.Fatalf("SetDefaultSize(4096) error: %v", err)
+	}
+	if b := New(); b.Cap() != 4096 {
+		t.Fatalf("New after SetDefaultSize: Cap=%d, want 4096", b.Cap())
+	}
+	if old.Cap() != DefaultSize {
+		t.Fatalf("existing buffer changed: Cap=%d, want %d", old.Cap(), DefaultSize)
+	}
+
+	for _, n := range []int{0, -1, alloc.MaxSize + 1} {
+		if err := SetDefaultSize(n); err == nil {
+			t.Fatalf("SetDefaultSize(%d) should fail", n)
+		}
+	}
+	if b := New(); b.Cap() != 4096 {
+		t.Fatalf("rejected size changed the default: Cap=%d", b.Cap())
+	}
+}
+
+func TestConsumeLine(t *testing.T) {
+	b := NewSize(16)
+
+	_, _ = b.Write([]byte("GET /"))
+	if _, ok := b.ConsumeLine(); ok {
+		t.Fatal("ConsumeLine on a partial line should report ok=false")
+	}
+	if b.Len() != 5 {
+		t.Fatalf("partial line consumed: Len=%d", b.Len())
+	}
+
+	_, _ = b.Write([]byte(" HTTP/1.1\r\nHost: x\n\nrest"))
+	want := []string{"GET / HTTP/1.1\r", "Host: x", ""}
+	for _, w := range want {
+		line, ok := b.ConsumeLine()
+		if !ok || string(line) != w {
+			t.Fatalf("ConsumeLine=%q ok=%v, want %q", line, ok, w)
+		}
+	}
+	if _, ok := b.ConsumeLine(); ok {
+		t.Fatal("ConsumeLine without a newline should report ok=false")
+	}
+	if string(b.Bytes()) != "rest" {
+		t.Fatalf("remaining=%q, want %q", b.Bytes(), "rest")
+	}
+
+	_, _ = b.Write([]byte("\n"))
+	if line, ok := b.ConsumeLine(); !ok || string(line) != "rest" {
+		t.Fatalf("ConsumeLine=%q ok=%v, want %q", line, ok, "rest")
+	}
+	if !b.IsEmpty() {
+		t.Fatalf("buffer not drained: Len=%d", b.Len())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	b := NewSize(8)
+	_, _ = b.Write([]byte("abcd"))
+
+	snap := b.Snapshot()
+	view := b.Bytes()
+
+	// overwrite the same storage
+	b.Reset()
+	_, _ = b.Write([]byte("wxyz"))
+
+	if string(snap) != "abcd" {
+		t.Fatalf("Snapshot changed after write: %q", snap)
+	}
+	if string(view) != "wxyz" {
+		t.Fatalf("Bytes is expected to alias the buffer, got %q", view)
+	}
+
+	b.Release()
+	if string(snap) != "abcd" {
+		t.Fatalf("Snapshot changed after Release: %q", snap)
+	}
+
+	if NewSize(8).Snapshot() != nil {
+		t.Fatal("Snapshot of an empty buffer should be nil")
+	}
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	for _, size := range []int{64, 4096, 32 * 1024} {
+		buf := NewSize(size)
+		_, _ = buf.Write(make([]byte, size))
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = buf.Snapshot()
+			}
+		})
+	}
+}
+
+// mustPanic reports whether fn panics with ErrNegativeCount.
+func mustPanic(t *testing.T, name string, fn func()) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != ErrNegativeCount {
+			t.Fatalf("%s: recovered %v, want panic(ErrNegativeCount)", name, r)
+		}
+	}()
+	fn()
+}
+
+func TestNegativePolicy(t *testing.T) {
+	// default: only Extend panics
+	b := NewSize(8)
+	mustPanic(t, "Extend", func() { b.Extend(-1) })
+	if _, err := b.TryExtend(-1); err != ErrNegativeCount {
+		t.Fatalf("TryExtend(-1) err=%v, want ErrNegativeCount", err)
+	}
+	if _, err := b.ReadBytes(-1); err != ErrNegativeCount {
+		t.Fatalf("ReadBytes(-1) err=%v, want ErrNegativeCount", err)
+	}
+	if b.To(-1) != nil {
+		t.Fatal("To(-1) should return nil")
+	}
+	if n, err := b.WritePeekTo(io.Discard, -1); n != 0 || err != ErrNegativeCount {
+		t.Fatalf("WritePeekTo(-1) n=%d err=%v, want ErrNegativeCount", n, err)
+	}
+
+	// error: nothing panics
+	b.SetNegativePolicy(NegativeError)
+	if b.Extend(-1) != nil {
+		t.Fatal("Extend(-1) should return nil under NegativeError")
+	}
+	if _, err := b.TryExtend(-1); err != ErrNegativeCount {
+		t.Fatalf("TryExtend(-1) err=%v, want ErrNegativeCount", err)
+	}
+	if err := b.WriteByteN('x', -1); err != ErrNegativeCount {
+		t.Fatalf("WriteByteN(-1) err=%v, want ErrNegativeCount", err)
+	}
+	if _, ok := b.TakeHeader(-1); ok {
+		t.Fatal("TakeHeader(-1) should fail")
+	}
+	if n, err := b.WritePeekTo(io.Discard, -1); n != 0 || err != ErrNegativeCount {
+		t.Fatalf("WritePeekTo(-1) n=%d err=%v, want ErrNegativeCount", n, err)
+	}
+	if b.Len() != 0 {
+		t.Fatalf("negative calls changed the buffer: Len=%d", b.Len())
+	}
+
+	// panic: every method panics
+	b.SetNegativePolicy(NegativePanic)
+	mustPanic(t, "Extend", func() { b.Extend(-1) })
+	mustPanic(t, "TryExtend", func() { _, _ = b.TryExtend(-1) })
+	mustPanic(t, "To", func() { b.To(-1) })
+	mustPanic(t, "ReadBytes", func() { _, _ = b.ReadBytes(-1) })
+	mustPanic(t, "WriteByteN", func() { _ = b.WriteByteN('x', -1) })
+	mustPanic(t, "TakeHeader", func() { b.TakeHeader(-1) })
+	mustPanic(t, "WritePeekTo", func() { _, _ = b.WritePeekTo(io.Discard, -1) })
+
+	// valid sizes are unaffected by the policy
+	if p := b.Extend(2); len(p) != 2 {
+		t.Fatalf("Extend(2) len=%d", len(p))
+	}
+}
+
+// chunkReader returns data in chunks of at most n bytes, with an empty
+// (0, nil) read before each chunk, then err.
+type chunkReader struct {
+	data  []byte
+	n     int
+	empty bool
+	err   error
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	if r.empty = !r.empty; r.empty {
+		return 0, nil
+	}
+	n := copy(p, r.data[:min(r.n, len(r.data))])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestReadFrom(t *testing.T) {
+	want := bytes.Repeat([]byte("0123456789"), 1000)
+	b := NewSize(16)
+	b.Write([]byte("head:"))
+
+	n, err := b.ReadFrom(&chunkReader{data: want, n: 7, err: io.EOF})
+	if err != nil {
+		t.Fatalf("ReadFrom error: %v", err)
+	}
+	if n != int64(len(want)) {
+		t.Fatalf("ReadFrom n=%d, want=%d", n, len(want))
+	}
+	if got := b.Bytes(); !bytes.Equal(got, append([]byte("head:"), want...)) {
+		t.Fatalf("ReadFrom content mismatch (len %d)", len(got))
+	}
+}
+
+func TestReadFromError(t *testing.T) {
+	b := NewSize(0)
+	n, err := b.ReadFrom(&chunkReader{data: []byte("partial"), n: 3, err: io.ErrUnexpectedEOF})
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected reader error, got %v", err)
+	}
+	if n != 7 || string(b.Bytes()) != "partial" {
+		t.Fatalf("ReadFrom n=%d data=%q, want 7 %q", n, b.Bytes(), "partial")
+	}
+}
+
+// shortWriter accepts at most n bytes per call.
+type shortWriter struct {
+	out bytes.Buffer
+	n   int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	return w.out.Write(p[:min(w.n, len(p))])
+}
+
+func TestWriteTo(t *testing.T) {
+	b := FromBytes([]byte("hello world"))
+	var w shortWriter
+	w.n = 4
+
+	n, err := b.WriteTo(&w)
+	if err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	if n != 11 || w.out.String() != "hello world" {
+		t.Fatalf("WriteTo n=%d out=%q", n, w.out.String())
+	}
+	if !b.IsEmpty() || b.start != 0 || b.end != 0 {
+		t.Fatalf("indexes not reset after drain: start=%d end=%d", b.start, b.end)
+	}
+
+	w.n = 0
+	b.Write([]byte("stuck"))
+	if n, err := b.WriteTo(&w); err != io.ErrShortWrite || n != 0 || b.Len() != 5 {
+		t.Fatalf("no-progress WriteTo n=%d err=%v Len=%d", n, err, b.Len())
+	}
+}
+
+func TestWriteToError(t *testing.T) {
+	b := FromBytes([]byte("payload"))
+
+	n, err := b.WriteTo(&errWriter{n: 3})
+	if err != io.ErrClosedPipe {
+		t.Fatalf("expected writer error, got %v", err)
+	}
+	if n != 3 || string(b.Bytes()) != "load" {
+		t.Fatalf("WriteTo n=%d remaining=%q, want 3 %q", n, b.Bytes(), "load")
+	}
+
+	var out bytes.Buffer
+	if n, err := b.WriteTo(&out); err != nil || n != 4 || out.String() != "load" {
+		t.Fatalf("retry n=%d err=%v out=%q", n, err, out.String())
+	}
+}
+
+func TestPeek(t *testing.T) {
+	b := FromBytes([]byte("\x00\x05hello"))
+
+	hdr, err := b.Peek(2)
+	if err != nil || !bytes.Equal(hdr, []byte{0, 5}) {
+		t.Fatalf("Peek(2)=%q err=%v", hdr, err)
+	}
+	if b.Len() != 7 {
+		t.Fatalf("Peek consumed data: Len=%d", b.Len())
+	}
+	if _, err := b.Peek(8); err != ErrIncomplete {
+		t.Fatalf("Peek past Len: err=%v, want ErrIncomplete", err)
+	}
+	if p, err := b.Peek(0); err != nil || len(p) != 0 {
+		t.Fatalf("Peek(0)=%q err=%v", p, err)
+	}
+	if _, err := b.Peek(-1); err != ErrNegativeCount {
+		t.Fatalf("Peek(-1) err=%v, want ErrNegativeCount", err)
+	}
+
+	// the peeked slice aliases the buffer
+	all, _ := b.Peek(7)
+	all[2] = 'H'
+	if got := string(b.Bytes()[2:]); got != "Hello" {
+		t.Fatalf("Peek slice does not alias the buffer: %q", got)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	b := FromBytes([]byte("padding:data"))
+
+	if n, err := b.Discard(0); n != 0 || err != nil || b.Len() != 12 {
+		t.Fatalf("Discard(0) n=%d err=%v Len=%d", n, err, b.Len())
+	}
+	if n, err := b.Discard(8); n != 8 || err != nil || string(b.Bytes()) != "data" {
+		t.Fatalf("Discard(8) n=%d err=%v rest=%q", n, err, b.Bytes())
+	}
+	if n, err := b.Discard(4); n != 4 || err != nil {
+		t.Fatalf("Discard(Len) n=%d err=%v", n, err)
+	}
+	if b.start != 0 || b.end != 0 {
+		t.Fatalf("indexes not reset: start=%d end=%d", b.start, b.end)
+	}
+
+	b.Write([]byte("abc"))
+	if n, err := b.Discard(10); n != 3 || err != io.EOF || !b.IsEmpty() {
+		t.Fatalf("Discard past Len n=%d err=%v Len=%d", n, err, b.Len())
+	}
+	if _, err := b.Discard(-1); err != ErrNegativeCount {
+		t.Fatalf("Discard(-1) err=%v, want ErrNegativeCount", err)
+	}
+
+	b.Write(make([]byte, 256))
+	if allocs := testing.AllocsPerRun(100, func() { b.Discard(1) }); allocs != 0 {
+		t.Fatalf("Discard allocates: %v", allocs)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	b := NewSize(16)
+	b.Write([]byte("hdr:"))
+
+	// reserve room for the largest frame, then shrink to the real size
+	p := b.Extend(10)
+	n := copy(p, "body")
+	b.Truncate(4 + n)
+	if got := string(b.Bytes()); got != "hdr:body" {
+		t.Fatalf("after Truncate: %q", got)
+	}
+
+	b.Write([]byte("!"))
+	if got := string(b.Bytes()); got != "hdr:body!" {
+		t.Fatalf("write after Truncate: %q", got)
+	}
+	if p[n] != '!' {
+		t.Fatal("Extend slice should alias the bytes written after Truncate")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Truncate past Len did not panic")
+			}
+		}()
+		b.Truncate(b.Len() + 1)
+	}()
+	mustPanic(t, "Truncate(-1)", func() { b.Truncate(-1) })
+
+	c := b.Cap()
+	b.Truncate(0)
+	if !b.IsEmpty() || b.Cap() != c {
+		t.Fatalf("Truncate(0) Len=%d Cap=%d, want 0 %d", b.Len(), b.Cap(), c)
+	}
+}
+
+func TestClone(t *testing.T) {
+	b := NewSize(64)
+	b.Write([]byte("xxhello"))
+	b.Discard(2)
+
+	c := b.Clone()
+	if !c.pooled {
+		t.Fatal("clone of a small buffer should come from the pool")
+	}
+	b.Bytes()[0] = 'J'
+	b.Write([]byte(" world"))
+	if got := string(c.Bytes()); got != "hello" {
+		t.Fatalf("clone changed with the original: %q", got)
+	}
+	c.Write([]byte("!"))
+	if got := string(b.Bytes()); got != "Jello world" {
+		t.Fatalf("original changed with the clone: %q", got)
+	}
+
+	b.Release()
+	if got := string(c.Bytes()); got != "hello!" {
+		t.Fatalf("clone changed after releasing the original: %q", got)
+	}
+	c.Release()
+
+	if e := NewSize(0).Clone(); !e.IsEmpty() {
+		t.Fatalf("clone of an empty buffer has Len=%d", e.Len())
+	}
+}
+
+func TestGrow(t *testing.T) {
+	b := NewSize(8)
+	b.Write([]byte("abc"))
+	b.Grow(100)
+	if b.Len() != 3 || b.Cap()-b.end < 100 {
+		t.Fatalf("Grow(100) Len=%d free=%d", b.Len(), b.Cap()-b.end)
+	}
+	c := b.Cap()
+	if allocs := testing.AllocsPerRun(1, func() {
+		b.Write(make([]byte, 100))
+		b.end -= 100
+	}); allocs != 0 {
+		t.Fatalf("writes within the grown space allocate: %v", allocs)
+	}
+	b.Grow(0)
+	b.Grow(-1)
+	if b.Cap() != c || string(b.Bytes()) != "abc" {
+		t.Fatalf("Grow(<=0) changed the buffer: Cap=%d data=%q", b.Cap(), b.Bytes())
+	}
+}
+
+func benchmarkWrites(b *testing.B, grow bool) {
+	chunk := make([]byte, 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := NewSize(0)
+		if grow {
+			buf.Grow(64 * len(chunk))
+		}
+		for range 64 {
+			buf.Write(chunk)
+		}
+	}
+}
+
+func BenchmarkWriteNoGrow(b *testing.B) { benchmarkWrites(b, false) }
+func BenchmarkWriteGrow(b *testing.B)   { benchmarkWrites(b, true) }
+
+// Operations that happen to restore ReadByte's indexes must still
+// invalidate UnreadByte.
+func TestUnreadByteAfterRestoredIndexes(t *testing.T) {
+	b := NewSize(8)
+	b.Write([]byte("a"))
+	if c, _ := b.ReadByte(); c != 'a' {
+		t.Fatalf("ReadByte=%q", c)
+	}
+	b.Write([]byte("xy"))
+	if p, err := b.ReadBytes(2); err != nil || string(p) != "xy" {
+		t.Fatalf("ReadBytes=%q err=%v", p, err)
+	}
+	if err := b.UnreadByte(); err != ErrUnreadByte {
+		t.Fatalf("UnreadByte after Write and Read err=%v, buffer %q", err, b.Bytes())
+	}
+
+	for name, op := range map[string]func(*Buffer){
+		"Truncate":  func(b *Buffer) { b.Truncate(b.Len()) },
+		"TrimSpace": func(b *Buffer) { b.TrimSpace() },
+		"WriteByte": func(b *Buffer) { b.WriteByte('z'); b.Truncate(b.Len() - 1) },
+		"Extend":    func(b *Buffer) { b.Extend(1); b.Truncate(b.Len() - 1) },
+	} {
+		b := FromBytes([]byte("abc"))
+		b.ReadByte()
+		op(b)
+		if err := b.UnreadByte(); err != ErrUnreadByte {
+			t.Fatalf("UnreadByte after %s err=%v", name, err)
+		}
+	}
+}
+
+func TestUnreadByte(t *testing.T) {
+	b := FromBytes([]byte("ab"))
+
+	if err := b.UnreadByte(); err != ErrUnreadByte {
+		t.Fatalf("UnreadByte before ReadByte err=%v", err)
+	}
+	c, _ := b.ReadByte()
+	if err := b.UnreadByte(); err != nil || c != 'a' || string(b.Bytes()) != "ab" {
+		t.Fatalf("UnreadByte err=%v data=%q", err, b.Bytes())
+	}
+	if err := b.UnreadByte(); err != ErrUnreadByte {
+		t.Fatalf("second UnreadByte err=%v", err)
+	}
+
+	// draining the last byte resets the indexes; unread restores it
+	b.ReadByte()
+	b.ReadByte()
+	if b.start != 0 || b.end != 0 {
+		t.Fatalf("indexes not reset: start=%d end=%d", b.start, b.end)
+	}
+	if err := b.UnreadByte(); err != nil || string(b.Bytes()) != "b" {
+		t.Fatalf("UnreadByte after drain err=%v data=%q", err, b.Bytes())
+	}
+
+	// any other operation in between invalidates it
+	b.ReadByte()
+	b.Write([]byte("xyz"))
+	if err := b.UnreadByte(); err != ErrUnreadByte {
+		t.Fatalf("UnreadByte after Write err=%v", err)
+	}
+	b.ReadByte()
+	b.Reset()
+	if err := b.UnreadByte(); err != ErrUnreadByte {
+		t.Fatalf("UnreadByte after Reset err=%v", err)
+	}
+
+	// compaction moves the rest to the front
+	b = NewSize(8)
+	b.SetCompactThreshold(1)
+	b.Write([]byte("123"))
+	b.ReadByte()
+	if err := b.UnreadByte(); err != nil || string(b.Bytes()) != "123" {
+		t.Fatalf("UnreadByte after compaction err=%v data=%q", err, b.Bytes())
+	}
+}
+
+func TestReadString(t *testing.T) {
+	b := FromBytes([]byte("GET / HTTP/1.1\r\nHost"))
+
+	line, err := b.ReadString('\n')
+	if err != nil || line != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("ReadString found: %q err=%v", line, err)
+	}
+	line, err = b.ReadString('\n')
+	if err != io.EOF || line != "Host" {
+		t.Fatalf("ReadString not found: %q err=%v, want %q io.EOF", line, err, "Host")
+	}
+	if !b.IsEmpty() {
+		t.Fatalf("ReadString left Len=%d", b.Len())
+	}
+	if line, err := b.ReadString('\n'); err != io.EOF || line != "" {
+		t.Fatalf("ReadString on empty buffer: %q err=%v", line, err)
+	}
+}
+
+func TestGrowReturnsPooledSlice(t *testing.T) {
+	// sync.Pool may drop items, so try a few times
+	for i := 0; i < 20; i++ {
+		b := NewSize(64)
+		if !b.pooled {
+			t.Fatal("NewSize(64) should come from the pool")
+		}
+		old := &b.data[0]
+		b.Write([]byte("keep"))
+		b.Grow(1024)
+		if string(b.Bytes()) != "keep" || b.pooled {
+			t.Fatalf("after grow data=%q pooled=%v", b.Bytes(), b.pooled)
+		}
+
+		got := alloc.Get(64)
+		if &got[0] == old {
+			return
+		}
+		_ = alloc.Put(got)
+	}
+	t.Fatal("the slice replaced by grow never came back from the pool")
+}
+
+func TestNewSizeMax(t *testing.T) {
+	b := NewSizeMax(4, 10)
+	if n, err := b.Write([]byte("0123456")); n != 7 || err != nil {
+		t.Fatalf("Write within cap n=%d err=%v", n, err)
+	}
+	n, err := b.Write([]byte("789abc"))
+	if err != ErrBufferFull || n != 3 {
+		t.Fatalf("Write past cap n=%d err=%v, want 3 ErrBufferFull", n, err)
+	}
+	if got := string(b.Bytes()); got != "0123456789" || b.Cap() > 10 {
+		t.Fatalf("content %q cap %d after overflow", got, b.Cap())
+	}
+	if err := b.WriteByte('x'); err != ErrBufferFull {
+		t.Fatalf("WriteByte when full err=%v", err)
+	}
+	if p := b.Extend(1); p != nil {
+		t.Fatalf("Extend when full returned %q", p)
+	}
+	if _, err := b.TryExtend(1); err != ErrBufferFull {
+		t.Fatalf("TryExtend when full err=%v", err)
+	}
+	if err := b.WriteUvarint(300); err != ErrBufferFull || b.Len() != 10 {
+		t.Fatalf("WriteUvarint when full err=%v Len=%d", err, b.Len())
+	}
+	if err := b.WriteVarint(-1); err != ErrBufferFull {
+		t.Fatalf("WriteVarint when full err=%v", err)
+	}
+	for _, put := range []func() error{
+		func() error { return b.PutUint16(1, binary.BigEndian) },
+		func() error { return b.PutUint32(1, binary.BigEndian) },
+		func() error { return b.PutUint64(1, binary.BigEndian) },
+	} {
+		if err := put(); err != ErrBufferFull || b.Len() != 10 {
+			t.Fatalf("PutUint when full err=%v Len=%d", err, b.Len())
+		}
+	}
+
+	// consuming makes room again, through compaction rather than growth
+	b.Discard(4)
+	if n, err := b.Write([]byte("wxyz")); n != 4 || err != nil {
+		t.Fatalf("Write after Discard n=%d err=%v", n, err)
+	}
+	if got := string(b.Bytes()); got != "456789wxyz" {
+		t.Fatalf("content after refill %q", got)
+	}
+
+	if err := b.WriteUvarintPrefixed(func(*Buffer) error { return nil }); err != ErrBufferFull {
+		t.Fatalf("WriteUvarintPrefixed when full err=%v", err)
+	}
+	if got := string(b.Bytes()); got != "456789wxyz" {
+		t.Fatalf("failed WriteUvarintPrefixed changed content: %q", got)
+	}
+}
+
+func TestReadFromMax(t *testing.T) {
+	b := NewSizeMax(0, 1000)
+	src := bytes.Repeat([]byte("x"), 1500)
+	n, err := b.ReadFrom(bytes.NewReader(src))
+	if err != ErrBufferFull || n != 1000 {
+		t.Fatalf("ReadFrom n=%d err=%v, want 1000 ErrBufferFull", n, err)
+	}
+	if b.Len() != 1000 || b.Cap() != 1000 {
+		t.Fatalf("Len=%d Cap=%d, want 1000 1000", b.Len(), b.Cap())
+	}
+}
+
+func TestIndex(t *testing.T) {
+	b := FromBytes([]byte("xxkey: value\r\n"))
+	b.Discard(2) // offsets are relative to the readable region
+
+	if i := b.IndexByte(':'); i != 3 {
+		t.Fatalf("IndexByte(':')=%d, want 3", i)
+	}
+	if i := b.IndexByte('k'); i != 0 {
+		t.Fatalf("IndexByte at start=%d, want 0", i)
+	}
+	if i := b.IndexByte('\n'); i != b.Len()-1 {
+		t.Fatalf("IndexByte at end=%d, want %d", i, b.Len()-1)
+	}
+	if i := b.IndexByte('x'); i != -1 {
+		t.Fatalf("IndexByte of consumed byte=%d, want -1", i)
+	}
+	if i := b.Index([]byte("\r\n")); i != b.Len()-2 {
+		t.Fatalf("Index(CRLF)=%d, want %d", i, b.Len()-2)
+	}
+	if i := b.Index([]byte("xxkey")); i != -1 {
+		t.Fatalf("Index spanning consumed bytes=%d, want -1", i)
+	}
+	if b.Len() != 12 {
+		t.Fatalf("search consumed data: Len=%d", b.Len())
+	}
+
+	e := NewSize(0)
+	if e.IndexByte('a') != -1 || e.Index([]byte("a")) != -1 {
+		t.Fatal("search in an empty buffer should return -1")
+	}
+}
+
+func TestString(t *testing.T) {
+	b := FromBytes([]byte("xxhello"))
+	b.Discard(2)
+
+	if got := b.String(); got != string(b.Bytes()) || got != "hello" {
+		t.Fatalf("String()=%q, want %q", got, "hello")
+	}
+	if b.Len() != 5 {
+		t.Fatalf("String consumed data: Len=%d", b.Len())
+	}
+	s := b.String()
+	b.Bytes()[0] = 'J'
+	if s != "hello" {
+		t.Fatalf("String aliases the buffer: %q", s)
+	}
+	if got := fmt.Sprintf("%s|%v", b, b); got != "Jello|Jello" {
+		t.Fatalf("formatted %q", got)
+	}
+
+	var nilBuf *Buffer
+	if nilBuf.String() != "" || NewSize(0).String() != "" {
+		t.Fatal("nil or empty buffer should return an empty string")
+	}
+}
+
+func TestFixedWidthRoundTrip(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		b := NewSize(4)
+		b.PutUint16(0x0102, order)
+		b.PutUint32(0x03040506, order)
+		b.PutUint64(0x0708090a0b0c0d0e, order)
+
+		want := make([]byte, 14)
+		order.PutUint16(want, 0x0102)
+		order.PutUint32(want[2:], 0x03040506)
+		order.PutUint64(want[6:], 0x0708090a0b0c0d0e)
+		if !bytes.Equal(b.Bytes(), want) {
+			t.Fatalf("%v: encoded % x, want % x", order, b.Bytes(), want)
+		}
+
+		v16, err := b.ReadUint16(order)
+		if err != nil || v16 != 0x0102 {
+			t.Fatalf("%v: ReadUint16=%#x err=%v", order, v16, err)
+		}
+		v32, err := b.ReadUint32(order)
+		if err != nil || v32 != 0x03040506 {
+			t.Fatalf("%v: ReadUint32=%#x err=%v", order, v32, err)
+		}
+		v64, err := b.ReadUint64(order)
+		if err != nil || v64 != 0x0708090a0b0c0d0e {
+			t.Fatalf("%v: ReadUint64=%#x err=%v", order, v64, err)
+		}
+		if !b.IsEmpty() {
+			t.Fatalf("%v: Len=%d after reading everything", order, b.Len())
+		}
+	}
+}
+
+func TestFixedWidthShortRead(t *testing.T) {
+	b := FromBytes([]byte{1, 2, 3})
+	if _, err := b.ReadUint32(binary.BigEndian); err != io.EOF {
+		t.Fatalf("ReadUint32 on 3 bytes err=%v, want io.EOF", err)
+	}
+	if _, err := b.ReadUint64(binary.BigEndian); err != io.EOF {
+		t.Fatalf("ReadUint64 on 3 bytes err=%v, want io.EOF", err)
+	}
+	if b.Len() != 3 {
+		t.Fatalf("short read consumed data: Len=%d", b.Len())
+	}
+	b.Discard(2)
+	if _, err := b.ReadUint16(binary.LittleEndian); err != io.EOF {
+		t.Fatalf("ReadUint16 on 1 byte err=%v, want io.EOF", err)
+	}
+}
+
+func TestFixedWidthNoAlloc(t *testing.T) {
+	b := NewSize(64)
+	if allocs := testing.AllocsPerRun(100, func() {
+		b.PutUint64(42, binary.BigEndian)
+		_, _ = b.ReadUint64(binary.BigEndian)
+	}); allocs != 0 {
+		t.Fatalf("fixed-width encode/decode allocates: %v", allocs)
+	}
+}
+
+func TestReadSlice(t *testing.T) {
+	b := NewSize(8)
+	b.SetCompactThreshold(1) // must not move aliased data
+	b.Write([]byte("abcdef"))
+
+	p, err := b.ReadSlice(3)
+	if err != nil || string(p) != "abc" || string(b.Bytes()) != "def" {
+		t.Fatalf("ReadSlice(3)=%q err=%v rest=%q", p, err, b.Bytes())
+	}
+	if _, err := b.ReadSlice(4); err != io.EOF || b.Len() != 3 {
+		t.Fatalf("ReadSlice past Len err=%v Len=%d", err, b.Len())
+	}
+	if _, err := b.ReadSlice(-1); err != ErrNegativeCount {
+		t.Fatalf("ReadSlice(-1) err=%v", err)
+	}
+
+	// the slice aliases the storage, so draining and writing again
+	// overwrites what it shows
+	b.ReadSlice(3)
+	b.Write([]byte("XYZ"))
+	if string(p) != "XYZ" {
+		t.Fatalf("slice should alias the buffer and see the next write, got %q", p)
+	}
+}
+
+func BenchmarkReadBytes(b *testing.B) {
+	benchmarkRead(b, func(buf *Buffer) ([]byte, error) { return buf.ReadBytes(8) })
+}
+
+func BenchmarkReadSlice(b *testing.B) {
+	benchmarkRead(b, func(buf *Buffer) ([]byte, error) { return buf.ReadSlice(8) })
+}
+
+func benchmarkRead(b *testing.B, read func(*Buffer) ([]byte, error)) {
+	buf := NewSize(4096)
+	data := make([]byte, 4096)
+	b.SetBytes(8)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if buf.IsEmpty() {
+			buf.Write(data)
+		}
+		if _, err := read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPrepend(t *testing.T) {
+	// head room left by consumed data is reused in place
+	b := NewSize(16)
+	b.Write([]byte("....payload"))
+	b.Discard(4)
+	data := &b.data[0]
+	if err := b.Prepend([]byte{0, 7}); err != nil {
+		t.Fatalf("Prepend error: %v", err)
+	}
+	if got := b.Bytes(); !bytes.Equal(got, []byte("\x00\x07payload")) {
+		t.Fatalf("after Prepend: %q", got)
+	}
+	if b.start != 2 || &b.data[0] != data {
+		t.Fatalf("Prepend with head room moved data: start=%d", b.start)
+	}
+
+	// no head room: the payload is shifted, reallocating if needed
+	b = NewSize(8)
+	b.Write([]byte("payload!"))
+	if err := b.Prepend([]byte("hdr:")); err != nil {
+		t.Fatalf("Prepend error: %v", err)
+	}
+	if got := b.String(); got != "hdr:payload!" {
+		t.Fatalf("after Prepend without head room: %q", got)
+	}
+
+	e := NewSize(0)
+	if err := e.Prepend([]byte("x")); err != nil || e.String() != "x" {
+		t.Fatalf("Prepend to empty buffer: %q err=%v", e.String(), err)
+	}
+
+	m := NewSizeMax(4, 4)
+	m.Write([]byte("abc"))
+	if err := m.Prepend([]byte("xy")); err != ErrBufferFull || m.String() != "abc" {
+		t.Fatalf("Prepend past the cap: %q err=%v", m.String(), err)
+	}
+}
+
+func TestInsertAt(t *testing.T) {
+	b := FromBytes([]byte("helloworld"))
+	if err := b.InsertAt(5, []byte(", ")); err != nil {
+		t.Fatalf("InsertAt error: %v", err)
+	}
+	if err := b.InsertAt(b.Len(), []byte("!")); err != nil {
+		t.Fatalf("InsertAt end error: %v", err)
+	}
+	if got := b.String(); got != "hello, world!" {
+		t.Fatalf("after InsertAt: %q", got)
+	}
+	for _, off := range []int{-1, b.Len() + 1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("InsertAt(%d) did not panic", off)
+				}
+			}()
+			b.InsertAt(off, []byte("x"))
+		}()
+	}
+}
+
+func TestNewSizeWithAllocator(t *testing.T) {
+	a := alloc.NewAllocator()
+	b := NewSizeWithAllocator(100, a)
+	if !b.pooled || b.Cap() != 100 {
+		t.Fatalf("pooled=%v Cap=%d", b.pooled, b.Cap())
+	}
+	b.Write([]byte("x"))
+	c := b.Clone()
+	if c.allocator != a {
+		t.Fatal("Clone should keep the allocator")
+	}
+	c.Release()
+
+	b.Release()
+	st := a.Stats()
+	if st.Classes[7].Gets != 1 || st.Classes[7].Puts != 1 || st.Classes[0].Puts != 1 {
+		t.Fatalf("storage not returned to the custom allocator: %+v", st.Classes)
+	}
+
+	// the slice replaced by grow goes back to the same allocator
+	g := NewSizeWithAllocator(64, a)
+	g.Grow(1024)
+	if got := a.Stats().Classes[6].Puts; got != 1 {
+		t.Fatalf("class 6 Puts=%d after grow, want 1", got)
+	}
+
+	// nil keeps the package-level allocator
+	d := NewSizeWithAllocator(10, nil)
+	if !d.pooled || d.allocator != nil {
+		t.Fatal("nil allocator should use the package default")
+	}
+	d.Release()
+}
+
+func TestEnsureCap(t *testing.T) {
+	b := NewSize(16)
+	b.Write([]byte("head"))
+	b.ReadByte()
+
+	const total = 5000
+	b.EnsureCap(total)
+	if b.Cap() != total || !b.pooled || string(b.Bytes()) != "ead" {
+		t.Fatalf("Cap=%d pooled=%v data=%q", b.Cap(), b.pooled, b.Bytes())
+	}
+	first := &b.data[0]
+	chunk := bytes.Repeat([]byte{'x'}, 100)
+	for b.Len()+len(chunk) <= total {
+		b.Write(chunk)
+	}
+	b.Write(chunk[:total-b.Len()])
+	if b.Len() != total || &b.data[0] != first {
+		t.Fatalf("writes up to total reallocated: Len=%d", b.Len())
+	}
+
+	b.EnsureCap(10) // already large enough
+	if &b.data[0] != first {
+		t.Fatal("EnsureCap below Cap should be a no-op")
+	}
+	b.Release()
+
+	big := NewSize(8)
+	big.EnsureCap(alloc.MaxSize + 1)
+	if big.Cap() != alloc.MaxSize+1 || big.pooled {
+		t.Fatalf("above MaxSize: Cap=%d pooled=%v", big.Cap(), big.pooled)
+	}
+
+	m := NewSizeMax(8, 64)
+	m.EnsureCap(1000)
+	if m.Cap() != 64 {
+		t.Fatalf("bounded Cap=%d, want 64", m.Cap())
+	}
+}
+
+func TestNewSizeWithAllocatorBelowMin(t *testing.T) {
+	a, err := alloc.NewAllocatorRange(9, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewSizeWithAllocator(100, a)
+	if b.pooled || b.Cap() != 100 {
+		t.Fatalf("below Min: pooled=%v Cap=%d", b.pooled, b.Cap())
+	}
+	b.EnsureCap(200)
+	if b.pooled {
+		t.Fatal("EnsureCap below Min should not mark the buffer pooled")
+	}
+	b.Grow(1000)
+	b.Release()
+
+	c := NewSizeWithAllocator(512, a)
+	if !c.pooled {
+		t.Fatal("NewSizeWithAllocator(512) should be pooled")
+	}
+	c.Release()
+	if st := a.Stats(); st.PutRejected != 0 || st.Classes[0].Puts != 1 {
+		t.Fatalf("stats=%+v", st)
+	}
+}
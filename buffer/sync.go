@@ -0,0 +1,61 @@
+package buffer
+
+import "sync"
+
+// SyncBuffer wraps a Buffer with a mutex so that one goroutine can write
+// while another reads, e.g. as a producer/consumer pipe. Read returns
+// io.EOF whenever the buffer is momentarily empty; it does not block.
+//
+// Only methods that copy data in or out are exposed. Bytes, Peek, Extend
+// and the other methods returning slices of the internal storage are left
+// out on purpose: those slices would be read or written after the lock is
+// released, racing with the other side.
+type SyncBuffer struct {
+	mu sync.Mutex
+	b  *Buffer
+}
+
+// NewSyncBuffer returns a SyncBuffer around b, which must not be used
+// directly afterwards. A nil b gets a buffer from New.
+func NewSyncBuffer(b *Buffer) *SyncBuffer {
+	if b == nil {
+		b = New()
+	}
+	return &SyncBuffer{b: b}
+}
+
+// Write appends p, as Buffer.Write.
+func (s *SyncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+// Read reads into p, as Buffer.Read.
+func (s *SyncBuffer) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Read(p)
+}
+
+// Len returns the number of readable bytes.
+func (s *SyncBuffer) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Len()
+}
+
+// Reset discards the buffered data.
+func (s *SyncBuffer) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.b.Reset()
+}
+
+// Release returns the storage to the pool, as Buffer.Release. The
+// SyncBuffer stays usable and allocates again on the next write.
+func (s *SyncBuffer) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.b.Release()
+}
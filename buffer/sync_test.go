@@ -0,0 +1,66 @@
+package buffer
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+// Run with -race: writers and a reader share the buffer.
+func TestSyncBufferConcurrent(t *testing.T) {
+	s := NewSyncBuffer(NewSize(64))
+	const writers, records = 4, 500
+	record := []byte("0123456789abcdef")
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < records; j++ {
+				if _, err := s.Write(record); err != nil {
+					t.Errorf("Write error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var got bytes.Buffer
+	p := make([]byte, 7)
+read:
+	for {
+		n, err := s.Read(p)
+		got.Write(p[:n])
+		if err != io.EOF {
+			continue
+		}
+		select {
+		case <-done:
+			if s.Len() == 0 {
+				break read
+			}
+		default:
+		}
+	}
+	want := bytes.Repeat(record, writers*records)
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("read %d bytes, want %d identical records", got.Len(), writers*records)
+	}
+
+	s.Write(record)
+	s.Reset()
+	if s.Len() != 0 {
+		t.Fatalf("Len after Reset=%d", s.Len())
+	}
+	s.Release()
+	if n, err := s.Write(record); n != len(record) || err != nil || s.Len() != len(record) {
+		t.Fatalf("Write after Release n=%d err=%v Len=%d", n, err, s.Len())
+	}
+}
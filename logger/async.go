@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// errLineDropped reports a line discarded because the queue was full, so
+// that it is not counted as written.
+var errLineDropped = errors.New("log line dropped: queue full")
+
+// asyncWriter hands lines to a background goroutine through a bounded
+// queue. When the queue is full the line is dropped instead of blocking
+// the caller.
+type asyncWriter struct {
+	w       io.Writer
+	queue   chan []byte
+	dropped *atomic.Uint64
+	done    chan struct{}
+}
+
+func newAsyncWriter(w io.Writer, size int, dropped *atomic.Uint64) *asyncWriter {
+	a := &asyncWriter{
+		w:       w,
+		queue:   make(chan []byte, size),
+		dropped: dropped,
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *asyncWriter) Write(p []byte) (int, error) {
+	line := append([]byte(nil), p...)
+	select {
+	case a.queue <- line:
+	default:
+		a.dropped.Add(1)
+		return 0, errLineDropped
+	}
+	return len(p), nil
+}
+
+func (a *asyncWriter) run() {
+	defer close(a.done)
+	for line := range a.queue {
+		_, _ = a.w.Write(line)
+	}
+}
+
+// close stops accepting lines and waits until the queued ones are written.
+// No Write may be in progress or follow.
+func (a *asyncWriter) close() {
+	close(a.queue)
+	<-a.done
+}
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stallWriter blocks every Write until released.
+type stallWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *stallWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestNonBlockingDropsWhenStalled(t *testing.T) {
+	w := &stallWriter{release: make(chan struct{})}
+	l := NewStdLogger(false, false, false, false, false)
+	l.SetOutput(w)
+	l.SetNonBlocking(10)
+
+	finished := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			l.Noticef("line %d", i)
+		}
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging blocked on a stalled writer")
+	}
+
+	dropped := l.Dropped()
+	if dropped == 0 {
+		t.Fatal("expected dropped records with a stalled writer")
+	}
+
+	close(w.release)
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	written := strings.Count(w.String(), "\n")
+	if uint64(written)+dropped != 100 {
+		t.Fatalf("written=%d dropped=%d, want a total of 100", written, dropped)
+	}
+}
+
+func TestNonBlockingDisable(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.SetNonBlocking(16)
+	l.Noticef("queued")
+	// disabling flushes the queue and restores the original writer
+	l.SetNonBlocking(0)
+	assertContains(t, buf, "[INF] queued")
+
+	buf.Reset()
+	l.Noticef("direct")
+	assertContains(t, buf, "[INF] direct")
+}
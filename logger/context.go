@@ -0,0 +1,73 @@
+package logger
+
+import (
+	"context"
+	"sync"
+)
+
+// contextField maps a context key to the field name it is logged under.
+type contextField struct {
+	key  any
+	name string
+}
+
+var contextFields struct {
+	sync.RWMutex
+	list []contextField
+}
+
+// RegisterContextField makes WithContext and FromContext log the value
+// stored under key in a context as the field name, e.g. a request ID.
+// Registering a key again changes its name. Fields are appended in
+// registration order. It is meant to be called during initialization.
+func RegisterContextField(key any, name string) {
+	contextFields.Lock()
+	defer contextFields.Unlock()
+	for i := range contextFields.list {
+		if contextFields.list[i].key == key {
+			contextFields.list[i].name = name
+			return
+		}
+	}
+	contextFields.list = append(contextFields.list, contextField{key, name})
+}
+
+// WithContext returns a logger that appends the registered fields found in
+// ctx to every record, sharing l's output and configuration like With. If
+// ctx holds none of them, l itself is returned.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	var fields []field
+
+	contextFields.RLock()
+	for _, cf := range contextFields.list {
+		if v := ctx.Value(cf.key); v != nil {
+			fields = append(fields, field{cf.name, v})
+		}
+	}
+	contextFields.RUnlock()
+
+	if len(fields) == 0 {
+		return l
+	}
+	return l.withFields(fields)
+}
+
+// loggerKey is the context key of the logger stored by NewContext.
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying l, for retrieval with
+// FromContext further down the call chain.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger stored in ctx by NewContext, with the
+// registered fields of ctx applied as by WithContext. It returns nil if ctx
+// carries no logger.
+func FromContext(ctx context.Context) *Logger {
+	l, _ := ctx.Value(loggerKey{}).(*Logger)
+	if l == nil {
+		return nil
+	}
+	return l.WithContext(ctx)
+}
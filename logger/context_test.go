@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testCtxKey string
+
+func TestWithContext(t *testing.T) {
+	RegisterContextField(testCtxKey("req"), "request_id")
+	RegisterContextField(testCtxKey("trace"), "trace_id")
+
+	l, buf := newTestStdLogger(t)
+	l.useTime = false
+
+	ctx := context.WithValue(context.Background(), testCtxKey("req"), "r-1")
+	ctx = context.WithValue(ctx, testCtxKey("trace"), "t-1")
+	l.WithContext(ctx).Noticef("handled")
+	assertContains(t, buf, "[INF] handled request_id=r-1 trace_id=t-1")
+
+	if got := l.WithContext(context.Background()); got != l {
+		t.Fatal("WithContext without registered values should return l")
+	}
+
+	buf.Reset()
+	if FromContext(ctx) != nil {
+		t.Fatal("FromContext without a stored logger should return nil")
+	}
+	FromContext(NewContext(ctx, l)).Warnf("from ctx")
+	assertContains(t, buf, "[WRN] from ctx request_id=r-1 trace_id=t-1")
+}
+
+func TestWithContextConcurrent(t *testing.T) {
+	RegisterContextField(testCtxKey("req"), "request_id")
+
+	l := NewStdLogger(false, false, false, false, false)
+	var buf lockedBuffer
+	l.SetOutput(&buf)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("r-%d", i)
+			ctx := NewContext(context.WithValue(context.Background(), testCtxKey("req"), id), l)
+			for j := 0; j < 50; j++ {
+				FromContext(ctx).Noticef("request %s", id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(buf.buf.String()), "\n")
+	if len(lines) != 100 {
+		t.Fatalf("got %d lines, want 100", len(lines))
+	}
+	for _, line := range lines {
+		var id string
+		if _, err := fmt.Sscanf(line, "[INF] request %s", &id); err != nil {
+			t.Fatalf("unexpected line %q", line)
+		}
+		if !strings.HasSuffix(line, " request_id="+id) {
+			t.Fatalf("line %q carries another request's ID", line)
+		}
+	}
+}
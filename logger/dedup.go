@@ -0,0 +1,188 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDedupEntries bounds the number of distinct messages SetDedup tracks.
+// Messages arriving while the table is full are logged without dedup.
+const maxDedupEntries = 1024
+
+type dedupKey struct {
+	level  Level
+	msg    string
+	fields string // rendered fields, so derived loggers stay apart
+}
+
+type dedupEntry struct {
+	start      time.Time // first occurrence in the current window
+	fields     []field   // fields of the first occurrence, for the summary
+	suppressed int
+}
+
+// dedupReport is a pending "(repeated N times)" summary.
+type dedupReport struct {
+	level      Level
+	msg        string
+	fields     []field
+	suppressed int
+}
+
+// deduper collapses identical messages logged within a window.
+type deduper struct {
+	window time.Duration
+	report func(level Level, msg string, fields []field, suppressed int)
+
+	mu      sync.Mutex
+	entries map[dedupKey]*dedupEntry
+	last    *dedupKey // key of the previous record, nil before the first
+	timer   *time.Timer
+	stopped bool
+}
+
+func newDeduper(window time.Duration, report func(Level, string, []field, int)) *deduper {
+	return &deduper{window: window, report: report, entries: make(map[dedupKey]*dedupEntry)}
+}
+
+// fieldsKey renders fields for use in a dedupKey.
+func fieldsKey(fields []field) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, f := range fields {
+		fmt.Fprintf(&b, "%s=%v\x00", f.key, f.value)
+	}
+	return b.String()
+}
+
+// allow reports whether a record should be written. Before that, it
+// reports the suppressed count of the previous message if this one
+// differs, and of this message if its previous window has ended.
+func (d *deduper) allow(level Level, msg string, fields []field) bool {
+	k := dedupKey{level, msg, fieldsKey(fields)}
+	now := time.Now()
+
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return true
+	}
+	var pending []dedupReport
+	if d.last != nil && *d.last != k {
+		// the run of the previous message ended; its window goes on
+		if le := d.entries[*d.last]; le != nil && le.suppressed > 0 {
+			pending = append(pending, dedupReport{d.last.level, d.last.msg, le.fields, le.suppressed})
+			le.suppressed = 0
+		}
+	}
+	d.last = &k
+
+	e := d.entries[k]
+	if e != nil && now.Sub(e.start) < d.window {
+		e.suppressed++
+		d.mu.Unlock()
+		d.flush(pending)
+		return false
+	}
+	switch {
+	case e != nil:
+		if e.suppressed > 0 {
+			pending = append(pending, dedupReport{level, msg, e.fields, e.suppressed})
+		}
+		e.start, e.suppressed = now, 0
+	case len(d.entries) < maxDedupEntries:
+		d.entries[k] = &dedupEntry{start: now, fields: fields}
+	}
+	d.arm()
+	d.mu.Unlock()
+
+	d.flush(pending)
+	return true
+}
+
+// flush writes the summaries in pending. d.mu must not be held.
+func (d *deduper) flush(pending []dedupReport) {
+	for _, p := range pending {
+		d.report(p.level, p.msg, p.fields, p.suppressed)
+	}
+}
+
+// arm starts the sweep timer if entries are tracked. d.mu must be held.
+func (d *deduper) arm() {
+	if d.timer == nil && len(d.entries) > 0 {
+		d.timer = time.AfterFunc(d.window, d.sweep)
+	}
+}
+
+// sweep drops entries whose window has ended, reporting their suppressed
+// repeats, and rearms the timer for the rest.
+func (d *deduper) sweep() {
+	now := time.Now()
+	var pending []dedupReport
+
+	d.mu.Lock()
+	d.timer = nil
+	if d.stopped {
+		d.mu.Unlock()
+		return
+	}
+	for k, e := range d.entries {
+		if now.Sub(e.start) >= d.window {
+			if e.suppressed > 0 {
+				pending = append(pending, dedupReport{k.level, k.msg, e.fields, e.suppressed})
+			}
+			delete(d.entries, k)
+		}
+	}
+	d.arm()
+	d.mu.Unlock()
+
+	d.flush(pending)
+}
+
+// stop disables d and reports all pending repeats.
+func (d *deduper) stop() {
+	d.mu.Lock()
+	d.stopped = true
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	var pending []dedupReport
+	for k, e := range d.entries {
+		if e.suppressed > 0 {
+			pending = append(pending, dedupReport{k.level, k.msg, e.fields, e.suppressed})
+		}
+	}
+	d.entries = nil
+	d.mu.Unlock()
+
+	d.flush(pending)
+}
+
+// SetDedup collapses identical messages (same level, formatted text and
+// fields, so records of different derived loggers stay apart) logged
+// within window: the first is written, repeats are dropped, and
+// "<message> (repeated N times)", with the message's fields, reports how
+// many were dropped once a different message is logged or the window has
+// passed. At most maxDedupEntries distinct messages are tracked at a time.
+// window <= 0 disables dedup, reporting pending repeats.
+func (l *Logger) SetDedup(window time.Duration) {
+	var d *deduper
+	if window > 0 {
+		d = newDeduper(window, func(level Level, msg string, fields []field, n int) {
+			l.write(&record{level: level, msg: fmt.Sprintf("%s (repeated %d times)", msg, n), fields: fields})
+		})
+	}
+	l.Lock()
+	old := l.dedup
+	l.dedup = d
+	l.Unlock()
+	if old != nil {
+		old.stop()
+	}
+}
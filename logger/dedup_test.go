@@ -0,0 +1,149 @@
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDedupTightLoop(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.SetDedup(time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2500; j++ {
+				l.Errorf("disk %s failed", "sda")
+			}
+		}()
+	}
+	wg.Wait()
+	if n := bytes.Count(buf.Bytes(), []byte("\n")); n != 1 {
+		t.Fatalf("got %d lines for 10000 identical errors, want 1", n)
+	}
+
+	// disabling reports what was dropped
+	l.SetDedup(0)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[1], "[ERR] disk sda failed (repeated 9999 times)") {
+		t.Fatalf("unexpected output %q", lines)
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	l := NewStdLogger(false, false, false, false, false)
+	var out lockedBuffer
+	l.SetOutput(&out)
+	l.SetDedup(20 * time.Millisecond)
+	defer l.SetDedup(0)
+
+	for i := 0; i < 100; i++ {
+		l.Warnf("flapping")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out.mu.Lock()
+		got := out.buf.String()
+		out.mu.Unlock()
+		if strings.Contains(got, "[WRN] flapping (repeated 99 times)") {
+			if n := strings.Count(got, "\n"); n != 2 {
+				t.Fatalf("got %d lines, want 2: %q", n, got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no repeat summary after the window: %q", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// after the window the message is logged again
+	l.Warnf("flapping")
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if n := strings.Count(out.buf.String(), "[WRN] flapping\n"); n != 2 {
+		t.Fatalf("message after the window logged %d times in total, want 2", n)
+	}
+}
+
+func TestDedupMessageChange(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.SetDedup(time.Hour)
+	defer l.SetDedup(0)
+
+	for i := 0; i < 5; i++ {
+		l.Errorf("flapping")
+	}
+	l.Errorf("other") // ends the run: the summary is written first
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{"[ERR] flapping", "[ERR] flapping (repeated 4 times)", "[ERR] other"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %q, want %q", lines, want)
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) {
+			t.Fatalf("line %d: %q, want %q", i, lines[i], want[i])
+		}
+	}
+
+	// the window goes on: a repeat after the summary is still dropped
+	l.Errorf("flapping")
+	if n := strings.Count(buf.String(), "\n"); n != 3 {
+		t.Fatalf("repeat within the window was written: %q", buf.String())
+	}
+}
+
+func TestDedupFields(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.SetDedup(time.Hour)
+
+	a, b := l.With("conn", 1), l.With("conn", 2)
+	for i := 0; i < 3; i++ {
+		a.Errorf("reset")
+	}
+	b.Errorf("reset") // same message, different fields: not a repeat
+
+	l.SetDedup(0)
+	got := buf.String()
+	for _, want := range []string{
+		"[ERR] reset conn=1\n",
+		"[ERR] reset (repeated 2 times) conn=1\n",
+		"[ERR] reset conn=2\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("missing %q in %q", want, got)
+		}
+	}
+	if n := strings.Count(got, "\n"); n != 3 {
+		t.Fatalf("got %d lines, want 3: %q", n, got)
+	}
+}
+
+func TestDedupBounded(t *testing.T) {
+	d := newDeduper(time.Hour, func(Level, string, []field, int) {})
+	defer d.stop()
+
+	for i := 0; i < maxDedupEntries+100; i++ {
+		if !d.allow(InfoLevel, strings.Repeat("x", i), nil) {
+			t.Fatalf("first occurrence %d suppressed", i)
+		}
+	}
+	d.mu.Lock()
+	n := len(d.entries)
+	d.mu.Unlock()
+	if n != maxDedupEntries {
+		t.Fatalf("tracking %d messages, want %d", n, maxDedupEntries)
+	}
+	// untracked messages are never suppressed
+	long := strings.Repeat("x", maxDedupEntries+50)
+	if !d.allow(InfoLevel, long, nil) || !d.allow(InfoLevel, long, nil) {
+		t.Fatal("untracked message suppressed")
+	}
+}
@@ -0,0 +1,51 @@
+package logger
+
+import "fmt"
+
+// badKey is used for a trailing value that has no key.
+const badKey = "!BADKEY"
+
+// Event emits a structured event at info level, for consumption by a
+// metrics pipeline rather than a human. fields are alternating keys and
+// values, e.g. Event("cache_miss", "key", k, "bytes", n). In JSON mode the
+// record is {"level":"INFO",...,"event":name,<fields>}; text and logfmt
+// render event=name followed by the fields. Events honor the level and the
+// sampler, and can be turned off on their own with SetEvents.
+func (l *Logger) Event(name string, fields ...any) {
+	if l.noEvents.Load() || !l.enabled(InfoLevel) {
+		return
+	}
+	r := record{level: InfoLevel, event: name, fields: fieldsFromKV(fields)}
+	if l.caller != callerOff {
+		r.pc = callerPC(2)
+	}
+	l.emit(&r)
+}
+
+// SetEvents enables or disables the output of Event without affecting
+// regular log records. Events are enabled by default.
+func (l *Logger) SetEvents(enabled bool) {
+	l.noEvents.Store(!enabled)
+}
+
+// fieldsFromKV converts alternating keys and values into fields. Keys that
+// are not strings are formatted with fmt.Sprint, and a trailing value
+// without a key is kept under badKey.
+func fieldsFromKV(kv []any) []field {
+	if len(kv) == 0 {
+		return nil
+	}
+	fields := make([]field, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 == len(kv) {
+			fields = append(fields, field{badKey, kv[i]})
+			break
+		}
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		fields = append(fields, field{key, kv[i+1]})
+	}
+	return fields
+}
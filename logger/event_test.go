@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEventJSON(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.useTime = false
+	_ = l.SetFormat(FormatJSON)
+
+	l.Event("cache_miss", "key", "user:1", "bytes", 512)
+
+	var m map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &m); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if m["event"] != "cache_miss" || m["key"] != "user:1" || m["bytes"] != float64(512) {
+		t.Fatalf("unexpected event object: %v", m)
+	}
+	if _, ok := m["msg"]; ok {
+		t.Fatalf("event should not carry a msg: %v", m)
+	}
+}
+
+func TestEventText(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.useTime = false
+
+	l.Event("login", "user", "bob smith")
+	assertContains(t, buf, `[INF] event=login user="bob smith"`)
+
+	buf.Reset()
+	_ = l.SetFormat(FormatLogfmt)
+	l.Event("login", "user", "bob")
+	assertContains(t, buf, "level=INFO event=login user=bob")
+}
+
+func TestSetEvents(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+
+	l.SetEvents(false)
+	l.Event("dropped")
+	l.Noticef("kept")
+	if bytes.Contains(buf.Bytes(), []byte("dropped")) {
+		t.Fatalf("event emitted while disabled: %q", buf.String())
+	}
+	assertContains(t, buf, "[INF] kept")
+
+	l.SetEvents(true)
+	l.Event("back")
+	assertContains(t, buf, "event=back")
+
+	// events follow the level threshold
+	buf.Reset()
+	l.SetLevel(WarnLevel)
+	l.Event("quiet")
+	if buf.Len() != 0 {
+		t.Fatalf("event emitted below the level threshold: %q", buf.String())
+	}
+}
+
+func TestFieldsFromKV(t *testing.T) {
+	got := fieldsFromKV([]any{"a", 1, 2, "b", "orphan"})
+	want := []field{{"a", 1}, {"2", "b"}, {badKey, "orphan"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("fieldsFromKV = %v, want %v", got, want)
+	}
+	if fieldsFromKV(nil) != nil {
+		t.Fatal("fieldsFromKV(nil) should be nil")
+	}
+}
@@ -0,0 +1,22 @@
+package logger
+
+// With returns a logger that appends key=value to every record it writes.
+// The derived logger shares everything else with l, including output,
+// level and rotation, so configuring or closing either affects both; l
+// itself does not get the field. Fields accumulate across chained calls.
+func (l *Logger) With(key string, value any) *Logger {
+	return l.withFields([]field{{key, value}})
+}
+
+// WithFields is With for several fields, given as alternating keys and
+// values as in Event.
+func (l *Logger) WithFields(kv ...any) *Logger {
+	return l.withFields(fieldsFromKV(kv))
+}
+
+func (l *Logger) withFields(fields []field) *Logger {
+	merged := make([]field, 0, len(l.fields)+len(fields))
+	merged = append(merged, l.fields...)
+	merged = append(merged, fields...)
+	return &Logger{loggerCore: l.loggerCore, fields: merged}
+}
@@ -0,0 +1,80 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+func TestWith(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.useTime = false
+
+	req := l.With("req", 42)
+	req.Noticef("start")
+	assertContains(t, buf, "[INF] start req=42")
+
+	buf.Reset()
+	user := req.WithFields("user", "bob smith", "admin", true)
+	user.Warnf("denied")
+	assertContains(t, buf, `[WRN] denied req=42 user="bob smith" admin=true`)
+
+	// parents are unaffected
+	buf.Reset()
+	req.Errorf("done")
+	l.Noticef("base")
+	if got := buf.String(); got != "[ERR] done req=42\n[INF] base\n" {
+		t.Fatalf("unexpected output %q", got)
+	}
+
+	// configuration is shared
+	buf.Reset()
+	l.SetLevel(ErrorLevel)
+	user.Noticef("hidden")
+	if buf.Len() != 0 {
+		t.Fatalf("derived logger ignored the base level: %q", buf.String())
+	}
+}
+
+func TestWithJSON(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	_ = l.SetFormat(FormatJSON)
+
+	l.With("req", 42).Noticef("start")
+	var m map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &m); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if m["req"] != float64(42) || m["msg"] != "start" {
+		t.Fatalf("unexpected JSON object: %v", m)
+	}
+}
+
+// Siblings derived from one parent must not share field storage.
+func TestWithSiblingsConcurrent(t *testing.T) {
+	l := NewStdLogger(false, false, false, false, false)
+	var out lockedBuffer
+	l.SetOutput(&out)
+
+	base := l.With("a", 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			child := base.With("child", i)
+			for j := 0; j < 100; j++ {
+				child.Noticef("x")
+				base.With("n", j).Noticef("y")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, line := range bytes.Split(bytes.TrimSpace(out.buf.Bytes()), []byte("\n")) {
+		if !bytes.Contains(line, []byte(" a=1")) || bytes.Count(line, []byte("=")) != 2 {
+			t.Fatalf("unexpected fields in %q", line)
+		}
+	}
+}
@@ -1,13 +1,18 @@
 package logger
 
 import (
+    "compress/gzip"
     "fmt"
+    "io"
+    "log"
     "os"
     "path/filepath"
     "strings"
     "sync"
     "sync/atomic"
     "time"
+
+    "github.com/ninepeach/ark/buffer"
 )
 
 // Default file permissions for log files.
@@ -31,11 +36,27 @@ type FileLogger struct {
     includeTimestamp      bool
     isClosed              bool
     maxBackupFiles        int
+    maxAge                time.Duration // backups older than this are purged, 0 disables
+    perms                 os.FileMode // mode for the log file, its reopens and backups
+    syncOnRotate          bool
+    diagnostics           bool
+    sizeLimited           bool          // set once a size limit is configured
+    interval              time.Duration // time-based rotation period, 0 disables
+    nextRotation          time.Time     // next scheduled rotation when interval > 0
+    timer                 *time.Timer
+    timerGen              uint64 // invalidates callbacks of replaced timers
+    compress              bool
+    compressing           sync.WaitGroup // background backup compressions
+    rotationHook          func(oldPath, backupPath string)
+    wbuf                  *buffer.Buffer // pending output in buffered mode
+    bufSize               int            // flush threshold for wbuf
+    flushStop             chan struct{}  // stops the periodic flush
+    lineCounts            [FatalLevel + 1]atomic.Uint64 // cumulative across rotations
 }
 
-func newFileLogger(filename, processIDPrefix string, includeTimestamp bool) (*FileLogger, error) {
+func newFileLogger(filename, processIDPrefix string, includeTimestamp bool, perms os.FileMode) (*FileLogger, error) {
     fileflags := os.O_WRONLY | os.O_APPEND | os.O_CREATE
-    file, err := os.OpenFile(filename, fileflags, defaultLogPerms)
+    file, err := os.OpenFile(filename, fileflags, perms)
     if err != nil {
         return nil, fmt.Errorf("unable to open log file %q: %w", filename, err)
     }
@@ -52,76 +73,323 @@ func newFileLogger(filename, processIDPrefix string, includeTimestamp bool) (*Fi
         currentSize:       stats.Size(),
         processIDPrefix:   processIDPrefix,
         includeTimestamp:  includeTimestamp,
+        perms:             perms,
     }
     return fl, nil
 }
 
 func (fl *FileLogger) setLimit(limit int64) {
     fl.Lock()
-    defer fl.Unlock()
-
     fl.originalRotationLimit, fl.rotationLimit = limit, limit
+    fl.sizeLimited = true
     atomic.StoreInt32(&fl.isRotationAllowed, 1)
-
     rotateNow := fl.currentSize > fl.rotationLimit
+    fl.Unlock()
+
+    // Logging goes back through Write, so it must happen after unlocking.
     if rotateNow && fl.logger != nil {
         fl.logger.Noticef("Rotating logfile...")
     }
 }
 
+// setInterval enables time-based rotation every d, aligned to local
+// midnight when d divides or is a multiple of a day. d <= 0 disables it.
+func (fl *FileLogger) setInterval(d time.Duration) {
+    fl.Lock()
+    defer fl.Unlock()
+
+    fl.interval = d
+    fl.nextRotation = time.Time{}
+    if d > 0 {
+        // scheduled rotation happens outside Write, so writes must lock
+        atomic.StoreInt32(&fl.isRotationAllowed, 1)
+        fl.nextRotation = nextRotationTime(time.Now(), d)
+    }
+    fl.scheduleRotation()
+}
+
+// nextRotationTime returns the first rotation boundary after now. Intervals
+// that divide a day are aligned to local midnight (a 1h interval rotates on
+// the hour), multiples of a day rotate at local midnight, and any other
+// interval is counted from now.
+func nextRotationTime(now time.Time, d time.Duration) time.Time {
+    const day = 24 * time.Hour
+    y, m, dd := now.Date()
+    midnight := time.Date(y, m, dd, 0, 0, 0, 0, now.Location())
+    switch {
+    case d%day == 0:
+        return midnight.AddDate(0, 0, int(d/day))
+    case day%d == 0:
+        return midnight.Add((now.Sub(midnight)/d + 1) * d)
+    default:
+        return now.Add(d)
+    }
+}
+
+// scheduleRotation (re)arms the timer for nextRotation, so that a file is
+// rotated on time even when nothing is written. fl must be locked.
+func (fl *FileLogger) scheduleRotation() {
+    fl.timerGen++
+    if fl.timer != nil {
+        fl.timer.Stop()
+        fl.timer = nil
+    }
+    if fl.interval <= 0 || fl.isClosed {
+        return
+    }
+    gen := fl.timerGen
+    fl.timer = time.AfterFunc(time.Until(fl.nextRotation), func() {
+        fl.rotateOnSchedule(gen)
+    })
+}
+
+func (fl *FileLogger) rotateOnSchedule(gen uint64) {
+    fl.Lock()
+    defer fl.Unlock()
+
+    if gen != fl.timerGen || fl.isClosed {
+        return
+    }
+    // a Write may already have rotated for this boundary
+    if !time.Now().Before(fl.nextRotation) {
+        if err := fl.rotate(); err != nil {
+            log.Printf("FileLogger: scheduled rotation failed: %v", err)
+        }
+    }
+    fl.scheduleRotation()
+}
+
+// setBuffered makes writes go to an in-memory buffer that is flushed to the
+// file once it holds size bytes, every interval (if > 0), before rotation
+// and on close.
+func (fl *FileLogger) setBuffered(size int, interval time.Duration) {
+    // buffered writes always take the mutex
+    atomic.StoreInt32(&fl.isRotationAllowed, 1)
+
+    fl.Lock()
+    defer fl.Unlock()
+    fl.wbuf = buffer.NewSize(size)
+    fl.bufSize = size
+    if interval > 0 {
+        fl.flushStop = make(chan struct{})
+        go fl.flushLoop(interval, fl.flushStop)
+    }
+}
+
+func (fl *FileLogger) flushLoop(interval time.Duration, stop chan struct{}) {
+    t := time.NewTicker(interval)
+    defer t.Stop()
+    for {
+        select {
+        case <-t.C:
+            fl.Lock()
+            if !fl.isClosed {
+                if err := fl.flush(); err != nil {
+                    log.Printf("FileLogger: periodic flush failed: %v", err)
+                }
+            }
+            fl.Unlock()
+        case <-stop:
+            return
+        }
+    }
+}
+
+// write sends p to the file, through the buffer in buffered mode.
+// fl must be locked.
+func (fl *FileLogger) write(p []byte) (int, error) {
+    if fl.wbuf == nil {
+        return fl.file.Write(p)
+    }
+    n, _ := fl.wbuf.Write(p)
+    if fl.wbuf.Len() >= fl.bufSize {
+        if err := fl.flush(); err != nil {
+            return n, err
+        }
+    }
+    return n, nil
+}
+
+// flush writes the buffered output to the file. Data that could not be
+// written stays buffered. fl must be locked.
+func (fl *FileLogger) flush() error {
+    if fl.wbuf == nil || fl.wbuf.IsEmpty() {
+        return nil
+    }
+    n, err := fl.file.Write(fl.wbuf.Bytes())
+    if err != nil {
+        _, _ = fl.wbuf.Discard(n)
+        return err
+    }
+    fl.wbuf.Reset()
+    return nil
+}
+
+func (fl *FileLogger) setCompress(on bool) {
+    fl.Lock()
+    defer fl.Unlock()
+    fl.compress = on
+}
+
+func (fl *FileLogger) setRotationHook(fn func(oldPath, backupPath string)) {
+    fl.Lock()
+    defer fl.Unlock()
+    fl.rotationHook = fn
+}
+
+// runRotationHook calls fn on its own goroutine, so rotation never waits on
+// it and it may log through the rotated logger. A panic in fn is recovered
+// and reported instead of taking the process down.
+func (fl *FileLogger) runRotationHook(fn func(oldPath, backupPath string), fname, bak string) {
+    defer func() {
+        if r := recover(); r != nil {
+            log.Printf("logger: rotation hook panicked for %q: %v", bak, r)
+        }
+    }()
+    fn(fname, bak)
+}
+
 func (fl *FileLogger) setMaxNumFiles(max int) {
     fl.Lock()
     defer fl.Unlock()
     fl.maxBackupFiles = max
 }
 
-func (fl *FileLogger) logDirect(label, format string, v ...any) int {
-    var logBuffer = [256]byte{}
-    logEntry := logBuffer[:0]
+func (fl *FileLogger) setMaxAge(d time.Duration) {
+    fl.Lock()
+    defer fl.Unlock()
+    fl.maxAge = d
+}
 
-    if fl.processIDPrefix != "" {
-        logEntry = append(logEntry, fl.processIDPrefix...)
+func (fl *FileLogger) setSyncOnRotate(sync bool) {
+    fl.Lock()
+    defer fl.Unlock()
+    fl.syncOnRotate = sync
+}
+
+// countLine records one line written at level.
+func (fl *FileLogger) countLine(level Level) {
+    if level >= TraceLevel && level <= FatalLevel {
+        fl.lineCounts[level].Add(1)
     }
+}
+
+func (fl *FileLogger) setDiagnostics(on bool) {
+    fl.Lock()
+    defer fl.Unlock()
+    fl.diagnostics = on
+}
 
-    if fl.includeTimestamp {
-        now := time.Now()
-        year, month, day := now.Date()
-        hour, min, sec := now.Clock()
-        microsec := now.Nanosecond() / 1000
-        logEntry = append(logEntry,
-            fmt.Sprintf("%04d/%02d/%02d %02d:%02d:%02d.%06d ",
-                year, month, day, hour, min, sec, microsec)...,
-        )
+// syncDir flushes the directory entry changes made by a rotation to disk.
+func syncDir(dir string) error {
+    d, err := os.Open(dir)
+    if err != nil {
+        return err
+    }
+    err = d.Sync()
+    if cerr := d.Close(); err == nil {
+        err = cerr
     }
+    return err
+}
 
-    logEntry = append(logEntry, label...)
-    logEntry = append(logEntry, fmt.Sprintf(format, v...)...)
+func (fl *FileLogger) logDirect(level Level, format string, v ...any) int {
+    var logBuffer = [256]byte{}
+    logEntry := logBuffer[:0]
+    msg := fmt.Sprintf(format, v...)
+
+    // append moves the entry to the heap if it outgrows logBuffer
+    if fl.logger != nil {
+        // rendered like any other record, so JSON and logfmt files stay
+        // one record per line
+        logEntry = fl.logger.directLine(logEntry, level, msg)
+    } else {
+        logEntry = append(logEntry, fl.processIDPrefix...)
+        if fl.includeTimestamp {
+            logEntry = time.Now().AppendFormat(logEntry, textTimeLayout)
+            logEntry = append(logEntry, ' ')
+        }
+        logEntry = append(logEntry, msg...)
+    }
     logEntry = append(logEntry, '\n')
 
+    // logDirect runs with fl locked, so errors cannot be reported through
+    // fl.logger (that would re-enter Write); fall back to stderr instead.
     if fl.file == nil {
         // 极端情况下 file 为空时直接丢弃，避免 panic
-        if fl.logger != nil {
-            fl.logger.Warnf("FileLogger: logDirect called with nil file")
-        }
+        log.Printf("FileLogger: logDirect called with nil file")
         return len(logEntry)
     }
 
-    _, err := fl.file.Write(logEntry)
-    if err != nil && fl.logger != nil {
-        fl.logger.Noticef("Error writing to log file: %v", err)
+    _, err := fl.write(logEntry)
+    if err != nil {
+        log.Printf("Error writing to log file: %v", err)
+    }
+    if err == nil {
+        fl.countLine(level)
     }
     return len(logEntry)
 }
 
+// backupStampLayout is the suffix appended to a log file name on rotation.
+const backupStampLayout = "2006.01.02.15.04.05.000000000"
+
+// purgeLocks holds one *sync.Mutex per directory so that loggers sharing a
+// directory never list and remove backups at the same time.
+var purgeLocks sync.Map
+
+// backupSuffix is appended to backups compressed by SetCompressBackups.
+const backupSuffix = ".gz"
+
+// backupStamp returns the rotation stamp of name if it is a backup of the
+// log file base, i.e. exactly base + "." + a full stamp, optionally
+// followed by backupSuffix. Backups of other files whose names merely start
+// with base (such as "app.log.old.<stamp>" for base "app.log") and partial
+// compressions never match.
+func backupStamp(name, base string) (string, bool) {
+    stamp, found := strings.CutPrefix(name, base+".")
+    if !found {
+        return "", false
+    }
+    stamp = strings.TrimSuffix(stamp, backupSuffix)
+    if len(stamp) != len(backupStampLayout) {
+        return "", false
+    }
+    if _, err := time.Parse(backupStampLayout, stamp); err != nil {
+        return "", false
+    }
+    return stamp, true
+}
+
+// purgeLock returns the mutex serializing backup changes in dir.
+func purgeLock(dir string) *sync.Mutex {
+    mu, _ := purgeLocks.LoadOrStore(dir, new(sync.Mutex))
+    return mu.(*sync.Mutex)
+}
+
+// isBackupOf reports whether name is a backup of the log file base.
+func isBackupOf(name, base string) bool {
+    _, ok := backupStamp(name, base)
+    return ok
+}
+
+// logPurge removes the oldest backups of fname beyond maxBackupFiles and
+// those older than maxAge.
+// Several loggers may share a directory as long as their file names
+// differ; two loggers writing the same file name are not supported.
 func (fl *FileLogger) logPurge(fname string) {
     var backups []string
     logDir := filepath.Dir(fname)
     logBase := filepath.Base(fname)
 
+    mu := purgeLock(logDir)
+    mu.Lock()
+    defer mu.Unlock()
+
     entries, err := os.ReadDir(logDir)
     if err != nil {
         if fl.logger != nil {
-            fl.logDirect(fl.logger.errorLabel,
+            fl.logDirect(ErrorLevel,
                 "Unable to read directory %q for log purge (%v), will attempt next rotation",
                 logDir, err,
             )
@@ -129,35 +397,125 @@ func (fl *FileLogger) logPurge(fname string) {
         return
     }
 
+    // A backup being compressed briefly exists both plain and compressed;
+    // it is counted once. Entries are sorted, so both names are adjacent.
     for _, entry := range entries {
-        if entry.IsDir() || entry.Name() == logBase || !strings.HasPrefix(entry.Name(), logBase) {
+        if entry.IsDir() {
             continue
         }
-        if stamp, found := strings.CutPrefix(entry.Name(), logBase+"."); found {
-            // stamp 形如 2006.01.02.15.04.05.999999999
-            _, err := time.Parse("2006:01:02:15:04:05.999999999", strings.Replace(stamp, ".", ":", 5))
-            if err == nil {
-                backups = append(backups, entry.Name())
+        stamp, ok := backupStamp(entry.Name(), logBase)
+        if ok && (len(backups) == 0 || backups[len(backups)-1] != stamp) {
+            backups = append(backups, stamp)
+        }
+    }
+
+    // backups are sorted by stamp, oldest first, so everything to purge is
+    // a prefix: the excess over the count limit plus anything too old
+    purge := 0
+    if fl.maxBackupFiles > 0 {
+        purge = max(len(backups)-(fl.maxBackupFiles-1), 0)
+    }
+    if fl.maxAge > 0 {
+        cutoff := time.Now().Add(-fl.maxAge)
+        for purge < len(backups) {
+            // stamps are written in local time; backupStamp already
+            // validated them, a failed parse just stops the age purge
+            t, err := time.ParseInLocation(backupStampLayout, backups[purge], time.Local)
+            if err != nil || !t.Before(cutoff) {
+                break
             }
+            purge++
         }
     }
 
-    currBackups := len(backups)
-    maxBackups := fl.maxBackupFiles - 1
-    if currBackups > maxBackups {
-        // backups 已按文件名排序（时间 + 名称），从最旧开始删
-        for i := 0; i < currBackups-maxBackups; i++ {
-            fullPath := filepath.Join(logDir, backups[i])
-            if err := os.Remove(fullPath); err != nil {
-                fl.logDirect(fl.logger.errorLabel,
+    for i := 0; i < purge; i++ {
+        for _, name := range []string{backups[i], backups[i] + backupSuffix} {
+            fullPath := filepath.Join(logDir, logBase+"."+name)
+            err := os.Remove(fullPath)
+            if os.IsNotExist(err) {
+                continue
+            }
+            if err != nil {
+                fl.logDirect(ErrorLevel,
                     "Unable to remove backup log file %q (%v), will attempt next rotation",
                     fullPath, err,
                 )
                 return
             }
-            fl.logDirect(fl.logger.infoLabel, "Purged log file %q", fullPath)
+            fl.logDirect(InfoLevel, "Purged log file %q", fullPath)
+        }
+    }
+}
+
+// compressBackup gzips the backup bak in the background and removes the
+// plain copy. On failure the plain backup is kept and the error logged.
+func (fl *FileLogger) compressBackup(bak string) {
+    defer fl.compressing.Done()
+
+    if err := gzipFile(bak, fl.perms); err != nil {
+        fl.Lock()
+        defer fl.Unlock()
+        if !fl.isClosed {
+            fl.currentSize += int64(fl.logDirect(ErrorLevel,
+                "Unable to compress backup log file %q (%v), keeping it uncompressed", bak, err,
+            ))
+        }
+    }
+}
+
+// gzipFile compresses name into name+".gz" and removes name. The output is
+// written under a temporary name and renamed once complete, so a crash
+// never leaves a truncated file that looks like a valid backup.
+func gzipFile(name string, perms os.FileMode) (err error) {
+    in, err := os.Open(name)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+
+    tmp := name + backupSuffix + ".tmp"
+    out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perms)
+    if err != nil {
+        return err
+    }
+    defer func() {
+        if err != nil {
+            _ = out.Close()
+            _ = os.Remove(tmp)
+        }
+    }()
+
+    zw := gzip.NewWriter(out)
+    if _, err = io.Copy(zw, in); err != nil {
+        return err
+    }
+    if err = zw.Close(); err != nil {
+        return err
+    }
+    if err = out.Sync(); err != nil {
+        return err
+    }
+    if err = out.Close(); err != nil {
+        return err
+    }
+
+    // Swap the files under the purge lock, so a purge sees either the
+    // plain or the compressed backup and never revives one it removed.
+    mu := purgeLock(filepath.Dir(name))
+    mu.Lock()
+    defer mu.Unlock()
+    if _, err = os.Stat(name); err != nil {
+        if os.IsNotExist(err) {
+            // purged while compressing
+            _ = os.Remove(tmp)
+            return nil
         }
+        return err
     }
+    if err = os.Rename(tmp, name+backupSuffix); err != nil {
+        return err
+    }
+    return os.Remove(name)
 }
 
 func (fl *FileLogger) Write(b []byte) (int, error) {
@@ -175,79 +533,189 @@ func (fl *FileLogger) Write(b []byte) (int, error) {
     defer fl.Unlock()
 
     // 原始写入
-    n, err := fl.file.Write(b)
+    n, err := fl.write(b)
     if err != nil {
         return n, fmt.Errorf("error writing to log file during rotation: %w", err)
     }
 
     fl.currentSize += int64(n)
 
+    rotate := fl.sizeLimited && fl.currentSize > fl.rotationLimit
+    if fl.interval > 0 && !time.Now().Before(fl.nextRotation) {
+        rotate = true
+    }
+    if fl.diagnostics && fl.logger != nil && fl.logger.enabled(DebugLevel) {
+        // logDirect bypasses Write, so this cannot trigger another rotation;
+        // its bytes are still accounted for in currentSize.
+        fl.currentSize += int64(fl.logDirect(DebugLevel,
+            "Rotation check: size=%d limit=%d rotate=%t",
+            fl.currentSize, fl.rotationLimit, rotate,
+        ))
+    }
+
     // 检查是否需要轮转
-    if fl.currentSize <= fl.rotationLimit {
+    if !rotate {
         return n, nil
     }
+    if err := fl.rotate(); err != nil {
+        return n, err
+    }
+
+    // 返回原始写入 b 的字节数和原始 err（此处为 nil）
+    return n, nil
+}
+
+// rotate renames the current file to a timestamped backup, reopens it and
+// purges old backups. Size- and time-based rotation both go through here
+// with fl locked, and each rotation moves nextRotation past now, so a
+// boundary is never rotated twice.
+func (fl *FileLogger) rotate() error {
+    if fl.interval > 0 {
+        fl.nextRotation = nextRotationTime(time.Now(), fl.interval)
+    }
 
     // 下面开始执行轮转流程
+    // buffered data belongs to the file being rotated out
+    if err := fl.flush(); err != nil {
+        return fmt.Errorf("error flushing log file before rotation: %w", err)
+    }
+
+    var syncErr error
+    if fl.syncOnRotate {
+        if f, ok := fl.file.(interface{ Sync() error }); ok {
+            syncErr = f.Sync()
+        }
+    }
+
     if err := fl.file.Close(); err != nil {
         fl.rotationLimit *= 2
         if fl.logger != nil {
-            fl.logDirect(fl.logger.errorLabel,
+            fl.logDirect(ErrorLevel,
                 "Unable to close logfile for rotation (%v), will attempt next rotation at size %v",
                 err, fl.rotationLimit,
             )
         }
-        return n, err
+        return err
     }
 
     fname := fl.file.Name()
     now := time.Now()
-    bak := fmt.Sprintf("%s.%04d.%02d.%02d.%02d.%02d.%02d.%09d",
-        fname,
-        now.Year(), now.Month(), now.Day(),
-        now.Hour(), now.Minute(), now.Second(), now.Nanosecond(),
-    )
+    bak := fname + "." + now.Format(backupStampLayout)
 
     if err := os.Rename(fname, bak); err != nil {
-        return n, fmt.Errorf("error renaming log file during rotation: %w", err)
+        return fmt.Errorf("error renaming log file during rotation: %w", err)
+    }
+
+    if fl.syncOnRotate && syncErr == nil {
+        syncErr = syncDir(filepath.Dir(fname))
+    }
+
+    if fl.compress && !fl.isClosed {
+        fl.compressing.Add(1)
+        go fl.compressBackup(bak)
     }
 
     fileflags := os.O_WRONLY | os.O_APPEND | os.O_CREATE
-    file, err := os.OpenFile(fname, fileflags, defaultLogPerms)
+    file, err := os.OpenFile(fname, fileflags, fl.perms)
     if err != nil {
-        return n, fmt.Errorf("unable to re-open the logfile %q after rotation: %w", fname, err)
+        return fmt.Errorf("unable to re-open the logfile %q after rotation: %w", fname, err)
     }
 
     fl.file = file
 
+    if fl.rotationHook != nil {
+        go fl.runRotationHook(fl.rotationHook, fname, bak)
+    }
+
     // 记录一次轮转成功的日志，这条日志的长度只用于 currentSize，不影响对外返回值
     if fl.logger != nil {
-        rotatedLen := fl.logDirect(fl.logger.infoLabel, "Rotated log, backup saved as %q", bak)
+        rotatedLen := fl.logDirect(InfoLevel, "Rotated log, backup saved as %q", bak)
         fl.currentSize = int64(rotatedLen)
     } else {
         fl.currentSize = 0
     }
 
+    if syncErr != nil && fl.logger != nil {
+        fl.currentSize += int64(fl.logDirect(ErrorLevel,
+            "Unable to sync rotated log file %q (%v)", bak, syncErr,
+        ))
+    }
+
     fl.rotationLimit = fl.originalRotationLimit
 
-    if fl.maxBackupFiles > 0 {
+    if fl.maxBackupFiles > 0 || fl.maxAge > 0 {
         fl.logPurge(fname)
     }
 
-    // 返回原始写入 b 的字节数和原始 err（此处为 nil）
-    return n, nil
+    return nil
 }
 
-func (fl *FileLogger) close() error {
+// reopen closes the log file and opens its path again, for use after an
+// external tool such as logrotate has renamed the file.
+func (fl *FileLogger) reopen() error {
+    // route unlocked writes through the mutex from now on
+    atomic.StoreInt32(&fl.isRotationAllowed, 1)
+
     fl.Lock()
     defer fl.Unlock()
 
     if fl.isClosed {
+        return fmt.Errorf("log file is closed")
+    }
+    if err := fl.flush(); err != nil {
+        return fmt.Errorf("error flushing log file before reopen: %w", err)
+    }
+
+    fname := fl.file.Name()
+    fileflags := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+    file, err := os.OpenFile(fname, fileflags, fl.perms)
+    if err != nil {
+        return fmt.Errorf("unable to re-open log file %q: %w", fname, err)
+    }
+    stats, err := file.Stat()
+    if err != nil {
+        _ = file.Close()
+        return fmt.Errorf("unable to get file stats for %q: %w", fname, err)
+    }
+
+    old := fl.file
+    fl.file = file
+    fl.currentSize = stats.Size()
+    if err := old.Close(); err != nil {
+        return fmt.Errorf("error closing previous log file: %w", err)
+    }
+    return nil
+}
+
+func (fl *FileLogger) close() error {
+    fl.Lock()
+    if fl.isClosed {
+        fl.Unlock()
         return nil
     }
 
+    // once closed, no rotation starts another compression
     fl.isClosed = true
-    if err := fl.file.Close(); err != nil {
-        return fmt.Errorf("error closing log file: %w", err)
+    fl.scheduleRotation() // stops the timer
+    if fl.flushStop != nil {
+        close(fl.flushStop)
+    }
+    flushErr := fl.flush()
+    if fl.wbuf != nil {
+        fl.wbuf.Release()
+        fl.wbuf = nil
+    }
+    closeErr := fl.file.Close()
+    fl.Unlock()
+
+    // compressions log failures under fl's lock, so wait after releasing it
+    fl.compressing.Wait()
+
+    if closeErr != nil {
+        return fmt.Errorf("error closing log file: %w", closeErr)
+    }
+    if flushErr != nil {
+        return fmt.Errorf("error flushing log file: %w", flushErr)
     }
     return nil
 }
\ No newline at end of file
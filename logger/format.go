@@ -0,0 +1,352 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Format selects how log records are rendered.
+type Format int
+
+const (
+	// FormatText renders "[pid] time [LVL] message key=value" lines.
+	FormatText Format = iota
+	// FormatJSON renders one JSON object per line.
+	FormatJSON
+	// FormatLogfmt renders space separated key=value pairs.
+	FormatLogfmt
+)
+
+// LogFormat selects the initial output format of a logger, as SetFormat
+// does later on. Unknown formats leave the default text format in place.
+type LogFormat Format
+
+func (LogFormat) isLoggerOption() {}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case FormatText:
+		return "text"
+	case FormatJSON:
+		return "json"
+	case FormatLogfmt:
+		return "logfmt"
+	default:
+		return "Format(" + strconv.Itoa(int(f)) + ")"
+	}
+}
+
+// textTimeLayout matches log.LstdFlags|log.Lmicroseconds.
+const textTimeLayout = "2006/01/02 15:04:05.000000"
+
+// field is a single structured key/value pair attached to a record.
+type field struct {
+	key   string
+	value any
+}
+
+// record is a single log entry handed to a formatter.
+type record struct {
+	time    time.Time // zero when timestamps are disabled
+	pid     int       // zero when the pid is not logged
+	inst    string    // instance identifier, empty when unset
+	level   Level
+	label   string // text-mode level label, e.g. "[INF] "
+	msg     string
+	event   string  // event name; replaces msg when set
+	pc      uintptr // program counter of the logging call, when captured
+	caller  string  // file:line of the logging call, empty when disabled
+	fields  []field
+	durUnit time.Duration // unit for time.Duration fields, zero for Duration.String
+	layout  string        // text-mode time layout, empty for textTimeLayout
+	errCopy bool          // copy to the SetErrorOutput writer, set by the level methods
+}
+
+// formatter renders a record into dst, without a trailing newline.
+type formatter interface {
+	format(dst []byte, r *record) []byte
+}
+
+func newFormatter(f Format) (formatter, error) {
+	switch f {
+	case FormatText:
+		return textFormatter{}, nil
+	case FormatJSON:
+		return jsonFormatter{}, nil
+	case FormatLogfmt:
+		return logfmtFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %v", f)
+	}
+}
+
+// ----------------------------------------------------------------------
+// Text
+// ----------------------------------------------------------------------
+
+type textFormatter struct{}
+
+func (textFormatter) format(dst []byte, r *record) []byte {
+	if r.pid != 0 {
+		dst = append(dst, '[')
+		dst = strconv.AppendInt(dst, int64(r.pid), 10)
+		dst = append(dst, "] "...)
+	}
+	if r.inst != "" {
+		dst = append(dst, '[')
+		dst = append(dst, r.inst...)
+		dst = append(dst, "] "...)
+	}
+	if !r.time.IsZero() {
+		layout := r.layout
+		if layout == "" {
+			layout = textTimeLayout
+		}
+		dst = r.time.AppendFormat(dst, layout)
+		dst = append(dst, ' ')
+	}
+	dst = append(dst, r.label...)
+	if r.caller != "" {
+		dst = append(dst, r.caller...)
+		dst = append(dst, ": "...)
+	}
+	if r.event != "" {
+		dst = append(dst, "event="...)
+		dst = appendLogfmtString(dst, r.event)
+	} else {
+		dst = append(dst, r.msg...)
+	}
+	for _, f := range r.fields {
+		dst = append(dst, ' ')
+		dst = append(dst, f.key...)
+		dst = append(dst, '=')
+		dst = appendLogfmtValue(dst, f.value, r.durUnit)
+	}
+	return dst
+}
+
+// ----------------------------------------------------------------------
+// Field values
+// ----------------------------------------------------------------------
+
+// durationSuffixes maps the units accepted by SetDurationUnit to their
+// suffix.
+var durationSuffixes = map[time.Duration]string{
+	time.Nanosecond:  "ns",
+	time.Microsecond: "us",
+	time.Millisecond: "ms",
+	time.Second:      "s",
+	time.Minute:      "m",
+	time.Hour:        "h",
+}
+
+// formatTimeValue renders time.Duration and time.Time field values in a
+// stable, parseable form: durations via Duration.String or the unit set
+// with SetDurationUnit, times as RFC 3339 (second precision). It reports
+// false for any other type.
+func formatTimeValue(v any, unit time.Duration) (string, bool) {
+	switch v := v.(type) {
+	case time.Duration:
+		if unit == 0 {
+			return v.String(), true
+		}
+		return strconv.FormatFloat(float64(v)/float64(unit), 'f', -1, 64) + durationSuffixes[unit], true
+	case time.Time:
+		return v.Format(time.RFC3339), true
+	}
+	return "", false
+}
+
+// ----------------------------------------------------------------------
+// JSON
+// ----------------------------------------------------------------------
+
+type jsonFormatter struct{}
+
+func (jsonFormatter) format(dst []byte, r *record) []byte {
+	dst = append(dst, '{')
+	if !r.time.IsZero() {
+		dst = append(dst, `"time":"`...)
+		dst = r.time.AppendFormat(dst, time.RFC3339Nano)
+		dst = append(dst, `",`...)
+	}
+	dst = append(dst, `"level":"`...)
+	dst = append(dst, r.level.String()...)
+	dst = append(dst, '"')
+	if r.pid != 0 {
+		dst = append(dst, `,"pid":`...)
+		dst = strconv.AppendInt(dst, int64(r.pid), 10)
+	}
+	if r.inst != "" {
+		dst = append(dst, `,"instance":`...)
+		dst = appendJSONString(dst, r.inst)
+	}
+	if r.caller != "" {
+		dst = append(dst, `,"caller":`...)
+		dst = appendJSONString(dst, r.caller)
+	}
+	if r.event != "" {
+		dst = append(dst, `,"event":`...)
+		dst = appendJSONString(dst, r.event)
+	} else {
+		dst = append(dst, `,"msg":`...)
+		dst = appendJSONString(dst, r.msg)
+	}
+	for _, f := range r.fields {
+		dst = append(dst, ',')
+		dst = appendJSONString(dst, f.key)
+		dst = append(dst, ':')
+		dst = appendJSONValue(dst, f.value, r.durUnit)
+	}
+	return append(dst, '}')
+}
+
+func appendJSONValue(dst []byte, v any, unit time.Duration) []byte {
+	if s, ok := formatTimeValue(v, unit); ok {
+		return appendJSONString(dst, s)
+	}
+	switch v := v.(type) {
+	case nil:
+		return append(dst, "null"...)
+	case string:
+		return appendJSONString(dst, v)
+	case bool:
+		return strconv.AppendBool(dst, v)
+	case int:
+		return strconv.AppendInt(dst, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(dst, v, 10)
+	case uint64:
+		return strconv.AppendUint(dst, v, 10)
+	case error:
+		return appendJSONString(dst, v.Error())
+	case fmt.Stringer:
+		return appendJSONString(dst, v.String())
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return appendJSONString(dst, fmt.Sprint(v))
+	}
+	return append(dst, b...)
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a quoted JSON string.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				dst = append(dst, "\ufffd"...)
+			} else {
+				dst = append(dst, s[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+		switch c {
+		case '"', '\\':
+			dst = append(dst, '\\', c)
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		case '\t':
+			dst = append(dst, '\\', 't')
+		default:
+			if c < 0x20 {
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			} else {
+				dst = append(dst, c)
+			}
+		}
+		i++
+	}
+	return append(dst, '"')
+}
+
+// ----------------------------------------------------------------------
+// logfmt
+// ----------------------------------------------------------------------
+
+type logfmtFormatter struct{}
+
+func (logfmtFormatter) format(dst []byte, r *record) []byte {
+	if !r.time.IsZero() {
+		dst = append(dst, "time="...)
+		dst = r.time.AppendFormat(dst, time.RFC3339Nano)
+		dst = append(dst, ' ')
+	}
+	dst = append(dst, "level="...)
+	dst = append(dst, r.level.String()...)
+	if r.pid != 0 {
+		dst = append(dst, " pid="...)
+		dst = strconv.AppendInt(dst, int64(r.pid), 10)
+	}
+	if r.inst != "" {
+		dst = append(dst, " instance="...)
+		dst = appendLogfmtString(dst, r.inst)
+	}
+	if r.caller != "" {
+		dst = append(dst, " caller="...)
+		dst = appendLogfmtString(dst, r.caller)
+	}
+	if r.event != "" {
+		dst = append(dst, " event="...)
+		dst = appendLogfmtString(dst, r.event)
+	} else {
+		dst = append(dst, " msg="...)
+		dst = appendLogfmtString(dst, r.msg)
+	}
+	for _, f := range r.fields {
+		dst = append(dst, ' ')
+		dst = append(dst, f.key...)
+		dst = append(dst, '=')
+		dst = appendLogfmtValue(dst, f.value, r.durUnit)
+	}
+	return dst
+}
+
+func appendLogfmtValue(dst []byte, v any, unit time.Duration) []byte {
+	if s, ok := formatTimeValue(v, unit); ok {
+		return appendLogfmtString(dst, s)
+	}
+	switch v := v.(type) {
+	case nil:
+		return append(dst, "nil"...)
+	case string:
+		return appendLogfmtString(dst, v)
+	case error:
+		return appendLogfmtString(dst, v.Error())
+	}
+	return appendLogfmtString(dst, fmt.Sprint(v))
+}
+
+// appendLogfmtString appends s, quoting it when it is empty or contains
+// spaces, quotes, '=' or control characters.
+func appendLogfmtString(dst []byte, s string) []byte {
+	if !needsQuoting(s) {
+		return append(dst, s...)
+	}
+	return strconv.AppendQuote(dst, s)
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,303 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFormatterText(t *testing.T) {
+	r := record{
+		time:   time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC),
+		pid:    42,
+		level:  InfoLevel,
+		label:  "[INF] ",
+		msg:    "hello",
+		fields: []field{{"user", "bob smith"}, {"n", 3}},
+	}
+	got := string(textFormatter{}.format(nil, &r))
+	want := `[42] 2024/01/02 03:04:05.000006 [INF] hello user="bob smith" n=3`
+	if got != want {
+		t.Fatalf("text format:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestFormatterJSONEscaping(t *testing.T) {
+	r := record{
+		level:  WarnLevel,
+		msg:    "say \"hi\"\nback\\slash\x01",
+		fields: []field{{"ok", true}, {"err", errTest("boom")}},
+	}
+	line := jsonFormatter{}.format(nil, &r)
+
+	var m map[string]any
+	if err := json.Unmarshal(line, &m); err != nil {
+		t.Fatalf("invalid JSON %q: %v", line, err)
+	}
+	if m["msg"] != r.msg {
+		t.Fatalf("msg round-trip: got %q, want %q", m["msg"], r.msg)
+	}
+	if m["level"] != "WARN" || m["ok"] != true || m["err"] != "boom" {
+		t.Fatalf("unexpected JSON object: %v", m)
+	}
+	if _, ok := m["time"]; ok {
+		t.Fatalf("time should be omitted when timestamps are disabled: %s", line)
+	}
+}
+
+func TestFormatterLogfmt(t *testing.T) {
+	r := record{
+		level:  ErrorLevel,
+		msg:    "disk full",
+		fields: []field{{"path", "/var/log"}, {"empty", ""}},
+	}
+	got := string(logfmtFormatter{}.format(nil, &r))
+	want := `level=ERROR msg="disk full" path=/var/log empty=""`
+	if got != want {
+		t.Fatalf("logfmt format:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+
+	if err := l.SetFormat(FormatJSON); err != nil {
+		t.Fatalf("SetFormat(JSON) error: %v", err)
+	}
+	l.Noticef("as %s", "json")
+	var m map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &m); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if m["msg"] != "as json" || m["level"] != "INFO" {
+		t.Fatalf("unexpected JSON object: %v", m)
+	}
+
+	buf.Reset()
+	if err := l.SetFormat(FormatLogfmt); err != nil {
+		t.Fatalf("SetFormat(logfmt) error: %v", err)
+	}
+	l.Warnf("as logfmt")
+	assertContains(t, buf, `level=WARN msg="as logfmt"`)
+
+	buf.Reset()
+	if err := l.SetFormat(FormatText); err != nil {
+		t.Fatalf("SetFormat(text) error: %v", err)
+	}
+	l.Errorf("as text")
+	assertContains(t, buf, "[ERR] as text")
+
+	if err := l.SetFormat(Format(99)); err == nil {
+		t.Fatal("SetFormat with an unknown format should fail")
+	}
+}
+
+func TestSetFormatConcurrent(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				l.Noticef("line %d", j)
+			}
+		}()
+	}
+	for j := 0; j < 50; j++ {
+		_ = l.SetFormat(Format(j % 2))
+	}
+	wg.Wait()
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if strings.HasPrefix(line, "{") {
+			if !json.Valid([]byte(line)) {
+				t.Fatalf("mixed line in JSON mode: %q", line)
+			}
+		} else if !strings.Contains(line, "[INF] line ") || strings.Contains(line, `"msg"`) {
+			t.Fatalf("mixed line in text mode: %q", line)
+		}
+	}
+}
+
+func TestNewJSONLogger(t *testing.T) {
+	l := NewJSONLogger(true, false, false, false)
+	var buf bytes.Buffer
+	l.SetOutput(&buf)
+
+	l.With("user", "bob").Noticef("said %q\nthen left", "hi")
+	var m map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &m); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if m["msg"] != "said \"hi\"\nthen left" || m["level"] != "INFO" || m["user"] != "bob" {
+		t.Fatalf("unexpected JSON object: %v", m)
+	}
+	if _, ok := m["time"]; !ok {
+		t.Fatalf("time missing: %v", m)
+	}
+	if strings.Contains(buf.String(), "[INF]") {
+		t.Fatalf("text label leaked into JSON: %q", buf.String())
+	}
+}
+
+// Rotation and purge notices follow the file's format.
+func TestJSONFileRotation(t *testing.T) {
+	l, fname := newTestFileLogger(t)
+	defer l.Close()
+	if err := l.SetFormat(FormatJSON); err != nil {
+		t.Fatalf("SetFormat error: %v", err)
+	}
+	if err := l.SetSizeLimit(200); err != nil {
+		t.Fatalf("SetSizeLimit error: %v", err)
+	}
+	if err := l.SetMaxNumFiles(3); err != nil {
+		t.Fatalf("SetMaxNumFiles error: %v", err)
+	}
+	for i := 0; i < 30; i++ {
+		l.Noticef("record number %d", i)
+	}
+
+	names := backups(t, fname)
+	if len(names) == 0 {
+		t.Fatal("expected rotated backups")
+	}
+	paths := []string{fname}
+	for _, name := range names {
+		paths = append(paths, filepath.Join(filepath.Dir(fname), name))
+	}
+	var notices int
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile error: %v", err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if !json.Valid([]byte(line)) {
+				t.Fatalf("%s: invalid JSON line %q", filepath.Base(path), line)
+			}
+			if strings.Contains(line, `"msg":"Rotated log`) || strings.Contains(line, `"msg":"Purged log file`) {
+				notices++
+			}
+		}
+	}
+	if notices == 0 {
+		t.Fatal("expected rotation notices in the files")
+	}
+}
+
+func TestLogFormatOption(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "json.log")
+	l, err := NewFileLogger(tmp, false, false, false, false, LogFormat(FormatLogfmt))
+	if err != nil {
+		t.Fatalf("NewFileLogger error: %v", err)
+	}
+	l.Noticef("up")
+	l.Close()
+	data, _ := os.ReadFile(tmp)
+	if string(data) != "level=INFO msg=up\n" {
+		t.Fatalf("unexpected file content %q", data)
+	}
+
+	// unknown formats fall back to text
+	std := NewStdLogger(false, false, false, false, false, LogFormat(99))
+	var buf bytes.Buffer
+	std.SetOutput(&buf)
+	std.Noticef("plain")
+	assertContains(t, &buf, "[INF] plain")
+}
+
+type errTest string
+
+func (e errTest) Error() string { return string(e) }
+
+func TestFormatterTimeValues(t *testing.T) {
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	r := record{
+		level:  InfoLevel,
+		label:  "[INF] ",
+		msg:    "done",
+		fields: []field{{"latency", 1230 * time.Millisecond}, {"at", ts}},
+	}
+
+	got := string(textFormatter{}.format(nil, &r))
+	want := "[INF] done latency=1.23s at=2024-05-06T07:08:09Z"
+	if got != want {
+		t.Fatalf("text:\n got %q\nwant %q", got, want)
+	}
+
+	got = string(logfmtFormatter{}.format(nil, &r))
+	want = "level=INFO msg=done latency=1.23s at=2024-05-06T07:08:09Z"
+	if got != want {
+		t.Fatalf("logfmt:\n got %q\nwant %q", got, want)
+	}
+
+	got = string(jsonFormatter{}.format(nil, &r))
+	want = `{"level":"INFO","msg":"done","latency":"1.23s","at":"2024-05-06T07:08:09Z"}`
+	if got != want {
+		t.Fatalf("json:\n got %q\nwant %q", got, want)
+	}
+
+	r.durUnit = time.Millisecond
+	got = string(jsonFormatter{}.format(nil, &r))
+	if !strings.Contains(got, `"latency":"1230ms"`) {
+		t.Fatalf("json with ms unit: %q", got)
+	}
+}
+
+func TestSetDurationUnit(t *testing.T) {
+	l, _ := newTestStdLogger(t)
+
+	if err := l.SetDurationUnit(time.Microsecond); err != nil {
+		t.Fatalf("SetDurationUnit(us) error: %v", err)
+	}
+	if err := l.SetDurationUnit(0); err != nil {
+		t.Fatalf("SetDurationUnit(0) error: %v", err)
+	}
+	if err := l.SetDurationUnit(3 * time.Second); err == nil {
+		t.Fatal("SetDurationUnit(3s) should fail")
+	}
+}
+
+func TestSetInstanceID(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.useTime = false
+
+	l.SetInstanceID("web-1")
+	l.Noticef("up")
+	assertContains(t, buf, "[web-1] [INF] up")
+
+	buf.Reset()
+	_ = l.SetFormat(FormatJSON)
+	l.Noticef("up")
+	var m map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &m); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if m["instance"] != "web-1" {
+		t.Fatalf("instance field missing: %v", m)
+	}
+
+	buf.Reset()
+	_ = l.SetFormat(FormatLogfmt)
+	l.Noticef("up")
+	assertContains(t, buf, "level=INFO instance=web-1 msg=up")
+}
+
+func TestLogHostname(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+	l := NewStdLogger(false, false, false, false, false, LogHostname(true))
+	var buf bytes.Buffer
+	l.SetOutput(&buf)
+	l.Noticef("up")
+	assertContains(t, &buf, "["+host+"] [INF] up")
+}
@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+)
+
+// NewLeveledFileLogger returns a logger writing each record to the file
+// configured for its level in files, e.g. INF to access.log and WRN, ERR
+// and FTL to error.log, and records of the other levels to defaultPath.
+// Levels sharing a path share one file. Every file is an independent
+// NewFileLogger with its own rotation: the file-logger setters such as
+// SetSizeLimit and SetMaxNumFiles apply to each file separately, and
+// FileFor returns a single file's logger to configure it differently.
+// Records are rendered by the file loggers, so the level, format, label,
+// timestamp, instance, duration and sampler setters are forwarded to all
+// of them. defaultPath may be empty if files covers every level. Close
+// closes all files.
+func NewLeveledFileLogger(files map[Level]string, defaultPath string, useTime, debug, trace, pid bool, opts ...LogOption) (*Logger, error) {
+	for lvl := range files {
+		if lvl < TraceLevel || lvl > FatalLevel {
+			return nil, fmt.Errorf("unable to create leveled file logger: invalid level %v", lvl)
+		}
+	}
+
+	l := newLogger(log.New(io.Discard, "", 0), useTime, debug, trace, pid, opts...)
+	setPlainLabelFormats(l)
+	l.byLevel = make([]*Logger, FatalLevel+1)
+	opened := make(map[string]*Logger)
+	for lvl := range l.byLevel {
+		path, ok := files[Level(lvl)]
+		if !ok {
+			path = defaultPath
+		}
+		if path == "" {
+			l.Close()
+			return nil, fmt.Errorf("unable to create leveled file logger: no file for level %v", Level(lvl))
+		}
+		path = filepath.Clean(path)
+		c := opened[path]
+		if c == nil {
+			var err error
+			if c, err = NewFileLogger(path, useTime, debug, trace, pid, opts...); err != nil {
+				l.Close()
+				return nil, err
+			}
+			opened[path] = c
+			l.children = append(l.children, c)
+			l.caller = max(l.caller, c.caller)
+		}
+		l.byLevel[lvl] = c
+	}
+	return l, nil
+}
+
+// FileFor returns the file logger that receives records of level from a
+// logger created with NewLeveledFileLogger, or nil for other loggers.
+func (l *Logger) FileFor(level Level) *Logger {
+	if level < 0 || int(level) >= len(l.byLevel) {
+		return nil
+	}
+	return l.byLevel[level]
+}
+
+// levelFiles returns the file loggers of a leveled file logger, which
+// render its records, or nil for other loggers.
+func (l *Logger) levelFiles() []*Logger {
+	if l.byLevel == nil {
+		return nil
+	}
+	return l.children
+}
+
+// files returns the file loggers behind l: its own, or one per file of a
+// leveled file logger. op names the caller in the error for other loggers.
+func (l *Logger) files(op string) ([]*FileLogger, error) {
+	if l.fl != nil {
+		return []*FileLogger{l.fl}, nil
+	}
+	if l.byLevel == nil {
+		return nil, fmt.Errorf("%s requires file logger", op)
+	}
+	fls := make([]*FileLogger, len(l.children))
+	for i, c := range l.children {
+		fls[i] = c.fl
+	}
+	return fls, nil
+}
@@ -0,0 +1,124 @@
+package logger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLeveledFileLogger(t *testing.T) {
+	dir := t.TempDir()
+	access := filepath.Join(dir, "access.log")
+	errLog := filepath.Join(dir, "error.log")
+	def := filepath.Join(dir, "other.log")
+
+	l, err := NewLeveledFileLogger(map[Level]string{
+		InfoLevel:  access,
+		WarnLevel:  errLog,
+		ErrorLevel: errLog,
+	}, def, false, true, false, false)
+	if err != nil {
+		t.Fatalf("NewLeveledFileLogger error: %v", err)
+	}
+	if l.FileFor(WarnLevel) != l.FileFor(ErrorLevel) || l.FileFor(InfoLevel) == l.FileFor(ErrorLevel) {
+		t.Fatal("levels sharing a path should share one file")
+	}
+
+	l.Noticef("request served")
+	l.Errorf("request failed")
+	l.Debugf("no file configured")
+	l.Tracef("below the level")
+
+	for path, want := range map[string]string{
+		access: "[INF] request served\n",
+		errLog: "[ERR] request failed\n",
+		def:    "[DBG] no file configured\n",
+	} {
+		if data, _ := os.ReadFile(path); string(data) != want {
+			t.Fatalf("%s: %q, want %q", filepath.Base(path), data, want)
+		}
+	}
+
+	// each file rotates on its own size
+	if err := l.SetSizeLimit(100); err != nil {
+		t.Fatalf("SetSizeLimit error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		l.Noticef("access record number %d to force rotation", i)
+	}
+	if len(backups(t, access)) == 0 || len(backups(t, errLog)) != 0 {
+		t.Fatalf("after INF writes: access backups=%v error backups=%v", backups(t, access), backups(t, errLog))
+	}
+	for i := 0; i < 5; i++ {
+		l.Errorf("error record number %d to force rotation", i)
+	}
+	if len(backups(t, errLog)) == 0 {
+		t.Fatal("error.log did not rotate")
+	}
+
+	// summed over the files; INF also counts the rotation notices
+	lc, err := l.LineCounts()
+	if err != nil || lc.ByLevel[ErrorLevel] != 6 || lc.ByLevel[DebugLevel] != 1 {
+		t.Fatalf("LineCounts=%+v err=%v", lc, err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	for _, c := range []*Logger{l.FileFor(InfoLevel), l.FileFor(ErrorLevel), l.FileFor(DebugLevel)} {
+		if !c.fl.isClosed {
+			t.Fatal("Close should close every file")
+		}
+	}
+}
+
+func TestLeveledFileLoggerSetters(t *testing.T) {
+	dir := t.TempDir()
+	access := filepath.Join(dir, "access.log")
+	errLog := filepath.Join(dir, "error.log")
+	l, err := NewLeveledFileLogger(map[Level]string{InfoLevel: access}, errLog, false, false, false, false)
+	if err != nil {
+		t.Fatalf("NewLeveledFileLogger error: %v", err)
+	}
+	defer l.Close()
+
+	l.SetLabels("INFO", "WARN", "ERROR", "FATAL", "DEBUG", "TRACE")
+	l.SetInstanceID("node1")
+	l.SetDebug(true)
+	if l.Level() != DebugLevel || l.FileFor(ErrorLevel).Level() != DebugLevel {
+		t.Fatal("SetDebug should reach the files")
+	}
+	l.Noticef("labeled")
+	l.Debugf("debug enabled")
+	if err := l.SetFormat(FormatJSON); err != nil {
+		t.Fatalf("SetFormat error: %v", err)
+	}
+	l.Errorf("as json")
+
+	if data, _ := os.ReadFile(access); string(data) != "[node1] [INFO] labeled\n" {
+		t.Fatalf("access.log: %q", data)
+	}
+	data, _ := os.ReadFile(errLog)
+	if !bytes.Contains(data, []byte("[node1] [DEBUG] debug enabled\n")) || !bytes.Contains(data, []byte(`"msg":"as json"`)) {
+		t.Fatalf("error.log: %q", data)
+	}
+}
+
+func TestLeveledFileLoggerErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewLeveledFileLogger(map[Level]string{InfoLevel: filepath.Join(dir, "a.log")}, "", false, false, false, false); err == nil {
+		t.Fatal("a level without file and no default path should fail")
+	}
+	if _, err := NewLeveledFileLogger(map[Level]string{Level(42): filepath.Join(dir, "a.log")}, filepath.Join(dir, "b.log"), false, false, false, false); err == nil {
+		t.Fatal("an invalid level should fail")
+	}
+
+	std := NewStdLogger(false, false, false, false, false)
+	if err := std.SetSizeLimit(10); err == nil || !bytes.Contains([]byte(err.Error()), []byte("SetSizeLimit requires file logger")) {
+		t.Fatalf("SetSizeLimit on stderr logger: %v", err)
+	}
+	if std.FileFor(InfoLevel) != nil {
+		t.Fatal("FileFor should be nil for other loggers")
+	}
+}
@@ -1,25 +1,118 @@
 package logger
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/syslog"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ninepeach/ark/buffer"
 )
 
+// Level identifies the severity of a log record.
+type Level int
+
+const (
+	TraceLevel Level = iota
+	DebugLevel
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+	FatalLevel
+)
+
+// String returns the upper-case name of the level, e.g. "INFO".
+func (lvl Level) String() string {
+	switch lvl {
+	case TraceLevel:
+		return "TRACE"
+	case DebugLevel:
+		return "DEBUG"
+	case InfoLevel:
+		return "INFO"
+	case WarnLevel:
+		return "WARN"
+	case ErrorLevel:
+		return "ERROR"
+	case FatalLevel:
+		return "FATAL"
+	default:
+		return fmt.Sprintf("Level(%d)", int(lvl))
+	}
+}
+
 // Logger represents the server logger (stdout or file-based).
+//
+// A Logger is a handle on a shared loggerCore plus the fields added with
+// With; derived loggers share output, level and all other configuration
+// with the logger they were derived from.
 type Logger struct {
+	*loggerCore
+	fields []field // never modified in place, so it can be shared
+}
+
+// loggerCore holds the state shared by a logger and its derived loggers.
+//
+// Every record is rendered by the active formatter into a complete line,
+// timestamp included, and written with a single call to the underlying
+// log.Logger, whose flags and prefix are left empty.
+//
+// Locking:
+//   - The embedded Mutex guards the configuration (formatter, labels,
+//     fields rendered into every record, level boosts). It is held only
+//     while reading or changing them, never during I/O.
+//   - writeMu serializes rendering and writing records, together with the
+//     state tied to the write path: the line buffer, the tail ring and the
+//     output writer. A record is rendered from a configuration snapshot, so
+//     a concurrent change applies to a whole line or not at all.
+//   - The order is writeMu, then the FileLogger mutex, then the Mutex:
+//     FileLogger reads the configuration back to render its own notices
+//     while holding its mutex, and no lock is taken while holding the Mutex.
+//   - The level is atomic; fl, useTime and utc are set at construction and
+//     never change.
+type loggerCore struct {
 	sync.Mutex
+	writeMu    sync.Mutex
 	logger     *log.Logger
-	debug      bool
-	trace      bool
+	formatter  formatter
+	level      atomic.Int32 // minimum Level emitted
+	boost      levelBoost
+	useTime    bool
+	utc        bool
+	pid        int
+	instance   string
+	timeLayout string // text-mode timestamp layout, empty for textTimeLayout
+	durUnit    time.Duration
+	sampler    *sampler
+	tail       *tailRing    // guarded by writeMu
+	async      *asyncWriter // non-nil in non-blocking mode, guarded by writeMu
+	dropped    atomic.Uint64
+	noEvents   atomic.Bool // set when Event output is disabled
+	buf        []byte      // line buffer, guarded by writeMu
 	infoLabel  string
 	warnLabel  string
 	errorLabel string
 	fatalLabel string
 	debugLabel string
 	traceLabel string
-	fl         *FileLogger // non-nil only when file logging is enabled
+	colored    bool           // labels carry ANSI color codes
+	fl         *FileLogger    // non-nil only when file logging is enabled
+	sysw       *syslog.Writer // non-nil for syslog loggers, replaces logger
+	children   []*Logger      // destinations of a multi logger
+	byLevel    []*Logger      // leveled file logger destination by Level
+	caller     callerMode
+	dedup      *deduper     // non-nil when SetDedup is active
+	exit       func(int)    // called by Fatalf, nil for os.Exit
+	errOut     io.Writer    // secondary output, guarded by writeMu
+	errMin     atomic.Int32 // minimum Level copied to errOut
 }
 
 type LogOption interface{ isLoggerOption() }
@@ -29,17 +122,90 @@ type LogUTC bool
 
 func (l LogUTC) isLoggerOption() {}
 
-func logFlags(useTime bool, opts ...LogOption) int {
-	flags := 0
-	if useTime {
-		flags = log.LstdFlags | log.Lmicroseconds
+// LogHostname sets the instance ID to os.Hostname() at construction.
+type LogHostname bool
+
+func (l LogHostname) isLoggerOption() {}
+
+// LogFilePerms sets the permissions of a file logger's log file, used
+// when it is created, recreated on rotation or reopen, and for compressed
+// backups. The process umask still applies. The default is 0640.
+type LogFilePerms os.FileMode
+
+func (l LogFilePerms) isLoggerOption() {}
+
+// LogColorAuto makes NewStdLogger color its labels only when stderr is a
+// terminal, overriding its colors argument. The check is made once, at
+// construction, so a later SetOutput does not change it.
+type LogColorAuto bool
+
+func (l LogColorAuto) isLoggerOption() {}
+
+// stderrIsTerminal reports whether os.Stderr is a terminal; tests replace it.
+var stderrIsTerminal = func() bool {
+	return isTerminal(os.Stderr.Fd())
+}
+
+// LogCaller adds the file:line of the logging call to every record, with
+// the file reduced to its base name.
+type LogCaller bool
+
+func (l LogCaller) isLoggerOption() {}
+
+// LogCallerFullPath is LogCaller keeping the full path of the file.
+type LogCallerFullPath bool
+
+func (l LogCallerFullPath) isLoggerOption() {}
+
+// callerMode selects how the caller is rendered.
+type callerMode int
+
+const (
+	callerOff callerMode = iota
+	callerBase
+	callerFull
+)
+
+func newLogger(out *log.Logger, useTime, debug, trace, pid bool, opts ...LogOption) *Logger {
+	l := &Logger{loggerCore: &loggerCore{
+		logger:    out,
+		formatter: textFormatter{},
+		useTime:   useTime,
+	}}
+	switch {
+	case trace:
+		l.level.Store(int32(TraceLevel))
+	case debug:
+		l.level.Store(int32(DebugLevel))
+	default:
+		l.level.Store(int32(InfoLevel))
+	}
+	if pid {
+		l.pid = os.Getpid()
 	}
 	for _, opt := range opts {
-		if utc, ok := opt.(LogUTC); ok && useTime && bool(utc) {
-			flags |= log.LUTC
+		switch o := opt.(type) {
+		case LogUTC:
+			l.utc = bool(o)
+		case LogHostname:
+			if o {
+				l.instance, _ = os.Hostname()
+			}
+		case LogCaller:
+			if o && l.caller == callerOff {
+				l.caller = callerBase
+			}
+		case LogCallerFullPath:
+			if o {
+				l.caller = callerFull
+			}
+		case LogFormat:
+			if f, err := newFormatter(Format(o)); err == nil {
+				l.formatter = f
+			}
 		}
 	}
-	return flags
+	return l
 }
 
 // ----------------------------------------------------------------------
@@ -47,18 +213,13 @@ func logFlags(useTime bool, opts ...LogOption) int {
 // ----------------------------------------------------------------------
 
 func NewStdLogger(useTime, debug, trace, colors, pid bool, opts ...LogOption) *Logger {
-	flags := logFlags(useTime, opts...)
-	prefix := ""
-	if pid {
-		prefix = pidPrefix()
-	}
+	l := newLogger(log.New(os.Stderr, "", 0), useTime, debug, trace, pid, opts...)
 
-	l := &Logger{
-		logger: log.New(os.Stderr, prefix, flags),
-		debug:  debug,
-		trace:  trace,
+	for _, opt := range opts {
+		if auto, ok := opt.(LogColorAuto); ok && bool(auto) {
+			colors = stderrIsTerminal()
+		}
 	}
-
 	if colors {
 		setColoredLabelFormats(l)
 	} else {
@@ -67,28 +228,56 @@ func NewStdLogger(useTime, debug, trace, colors, pid bool, opts ...LogOption) *L
 	return l
 }
 
+// NewJSONLogger returns a logger writing one JSON object per record to
+// stderr, e.g. {"time":...,"level":"INFO","msg":...}, with fields from With
+// as top-level keys. It is NewStdLogger with LogFormat(FormatJSON); file
+// loggers take the same option.
+func NewJSONLogger(useTime, debug, trace, pid bool, opts ...LogOption) *Logger {
+	opts = append(opts[:len(opts):len(opts)], LogFormat(FormatJSON))
+	return NewStdLogger(useTime, debug, trace, false, pid, opts...)
+}
+
+// NewMultiLogger returns a logger that fans every record out to loggers,
+// e.g. colored stderr plus a plain file. Each destination renders the
+// record with its own format, labels and timestamp settings and applies
+// its own level; a failing destination does not affect the others. The
+// multi logger's level starts at TRACE and acts as an additional filter.
+// Close closes every destination.
+func NewMultiLogger(loggers ...*Logger) *Logger {
+	l := newLogger(log.New(io.Discard, "", 0), false, false, true, false)
+	setPlainLabelFormats(l)
+	l.children = append([]*Logger(nil), loggers...)
+	for _, c := range loggers {
+		// capture callers for the destinations that render them
+		l.caller = max(l.caller, c.caller)
+	}
+	return l
+}
+
 // ----------------------------------------------------------------------
 // File logger
 // ----------------------------------------------------------------------
 
 func NewFileLogger(filename string, useTime, debug, trace, pid bool, opts ...LogOption) (*Logger, error) {
-	flags := logFlags(useTime, opts...)
 	prefix := ""
 	if pid {
 		prefix = pidPrefix()
 	}
 
-	fl, err := newFileLogger(filename, prefix, useTime)
+	perms := defaultLogPerms
+	for _, opt := range opts {
+		if p, ok := opt.(LogFilePerms); ok {
+			perms = os.FileMode(p)
+		}
+	}
+
+	fl, err := newFileLogger(filename, prefix, useTime, perms)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create file logger: %w", err)
 	}
 
-	l := &Logger{
-		logger: log.New(fl, prefix, flags),
-		debug:  debug,
-		trace:  trace,
-		fl:     fl,
-	}
+	l := newLogger(log.New(fl, "", 0), useTime, debug, trace, pid, opts...)
+	l.fl = fl
 
 	// FileLogger needs back-reference for internal logging; safe to set here
 	fl.Lock()
@@ -99,31 +288,497 @@ func NewFileLogger(filename string, useTime, debug, trace, pid bool, opts ...Log
 	return l, nil
 }
 
+// NewFileLoggerBuffered is NewFileLogger with writes collected in memory
+// and written to the file once bufSize bytes are pending, every
+// flushInterval (if > 0), before rotation and on Close. bufSize <= 0 uses
+// buffer.DefaultSize. Records still buffered are lost if the process exits
+// without calling Close.
+func NewFileLoggerBuffered(filename string, bufSize int, flushInterval time.Duration, useTime, debug, trace, pid bool, opts ...LogOption) (*Logger, error) {
+	l, err := NewFileLogger(filename, useTime, debug, trace, pid, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if bufSize <= 0 {
+		bufSize = buffer.DefaultSize
+	}
+	l.fl.setBuffered(bufSize, flushInterval)
+	return l, nil
+}
+
 // ----------------------------------------------------------------------
 // File-logger only features
 // ----------------------------------------------------------------------
 
 func (l *Logger) SetSizeLimit(limit int64) error {
-	l.Lock()
-	fl := l.fl
-	l.Unlock()
+	fls, err := l.files("SetSizeLimit")
+	if err != nil {
+		return err
+	}
+	for _, fl := range fls {
+		fl.setLimit(limit)
+	}
+	return nil
+}
+
+// SetRotationInterval rotates the log file every d, in addition to any
+// size limit. Intervals that divide a day are aligned to local midnight
+// (24h rotates at midnight, 1h on the hour); other intervals count from
+// now. A background timer rotates idle files on schedule. d <= 0 disables
+// time-based rotation.
+func (l *Logger) SetRotationInterval(d time.Duration) error {
+	fls, err := l.files("SetRotationInterval")
+	if err != nil {
+		return err
+	}
+	for _, fl := range fls {
+		fl.setInterval(d)
+	}
+	return nil
+}
 
+// ReopenLogFile reopens the log file by path, so that logging continues in
+// a new file after an external tool (e.g. logrotate) renamed the old one.
+// It is meant to be called from a SIGHUP handler; records being written
+// concurrently go entirely to either the old or the new file.
+func (l *Logger) ReopenLogFile() error {
+	if l.byLevel != nil {
+		var errs []error
+		for _, c := range l.children {
+			errs = append(errs, c.ReopenLogFile())
+		}
+		return errors.Join(errs...)
+	}
+	fl := l.fl
 	if fl == nil {
-		return fmt.Errorf("SetSizeLimit requires file logger")
+		return fmt.Errorf("ReopenLogFile requires file logger")
+	}
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	return fl.reopen()
+}
+
+// SetCompressBackups gzips each backup in the background after rotation,
+// producing fname.<stamp>.gz. Compressed and plain backups both count
+// towards SetMaxNumFiles. If compression fails the plain backup is kept.
+func (l *Logger) SetCompressBackups(on bool) error {
+	fls, err := l.files("SetCompressBackups")
+	if err != nil {
+		return err
+	}
+	for _, fl := range fls {
+		fl.setCompress(on)
 	}
-	fl.setLimit(limit)
 	return nil
 }
 
+// SetRotationHook registers fn to be called after each successful rotation
+// with the live log path and the backup it was renamed to, e.g. to upload
+// the backup. fn runs on its own goroutine without any logger lock held, so
+// calls for consecutive rotations may overlap, and with SetCompressBackups
+// the backup may already have been replaced by its ".gz".
+// A panic in fn is recovered. A nil fn removes the hook.
+func (l *Logger) SetRotationHook(fn func(oldPath, backupPath string)) error {
+	fls, err := l.files("SetRotationHook")
+	if err != nil {
+		return err
+	}
+	for _, fl := range fls {
+		fl.setRotationHook(fn)
+	}
+	return nil
+}
+
+// SetMaxNumFiles bounds the number of files kept, the active log included;
+// older backups are purged on rotation. Loggers may share a directory as
+// long as their file names differ.
 func (l *Logger) SetMaxNumFiles(max int) error {
+	fls, err := l.files("SetMaxNumFiles")
+	if err != nil {
+		return err
+	}
+	for _, fl := range fls {
+		fl.setMaxNumFiles(max)
+	}
+	return nil
+}
+
+// SetMaxAge purges backups whose rotation stamp is older than d, in
+// addition to any SetMaxNumFiles limit; a backup violating either is
+// removed. Purging runs on rotation. Zero disables the age limit.
+func (l *Logger) SetMaxAge(d time.Duration) error {
+	fls, err := l.files("SetMaxAge")
+	if err != nil {
+		return err
+	}
+	for _, fl := range fls {
+		fl.setMaxAge(d)
+	}
+	return nil
+}
+
+// SetSyncOnRotate makes rotation fsync the log file before it is renamed
+// and its directory afterwards, so the backup survives a crash right after
+// rotation. It costs two fsyncs per rotation and is off by default.
+func (l *Logger) SetSyncOnRotate(sync bool) error {
+	fls, err := l.files("SetSyncOnRotate")
+	if err != nil {
+		return err
+	}
+	for _, fl := range fls {
+		fl.setSyncOnRotate(sync)
+	}
+	return nil
+}
+
+// SetRotationDiagnostics logs every rotation decision (current size, limit
+// and outcome) at debug level. It only has an effect while debug logging is
+// enabled.
+func (l *Logger) SetRotationDiagnostics(on bool) error {
+	fls, err := l.files("SetRotationDiagnostics")
+	if err != nil {
+		return err
+	}
+	for _, fl := range fls {
+		fl.setDiagnostics(on)
+	}
+	return nil
+}
+
+// LineCounts holds the number of lines a file logger has written,
+// cumulative across rotations.
+type LineCounts struct {
+	Total   uint64
+	ByLevel map[Level]uint64
+}
+
+// LineCounts returns the number of records written to the log file,
+// including the logger's own rotation and purge messages. In non-blocking
+// mode a record counts once queued for the file; records dropped from a
+// full queue are counted by Dropped instead.
+func (l *Logger) LineCounts() (LineCounts, error) {
+	fls, err := l.files("LineCounts")
+	if err != nil {
+		return LineCounts{}, err
+	}
+	lc := LineCounts{ByLevel: make(map[Level]uint64)}
+	for _, fl := range fls {
+		for lvl := range fl.lineCounts {
+			if n := fl.lineCounts[lvl].Load(); n > 0 {
+				lc.ByLevel[Level(lvl)] += n
+				lc.Total += n
+			}
+		}
+	}
+	return lc, nil
+}
+
+// ResetLineCounts sets all line counters back to zero.
+func (l *Logger) ResetLineCounts() error {
+	fls, err := l.files("ResetLineCounts")
+	if err != nil {
+		return err
+	}
+	for _, fl := range fls {
+		for i := range fl.lineCounts {
+			fl.lineCounts[i].Store(0)
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------
+// Level
+// ----------------------------------------------------------------------
+
+// levelBoost tracks a temporary level raise started by BoostLevel.
+type levelBoost struct {
+	active  bool
+	restore Level
+	timer   *time.Timer
+	gen     uint64 // invalidates timers of superseded boosts
+}
+
+// SetLevel sets the minimum level that is emitted. It is safe to call while
+// other goroutines are logging. Enabling trace also enables debug. Any
+// boost in progress is cancelled, so its timer cannot override this choice.
+func (l *Logger) SetLevel(level Level) {
+	for _, c := range l.levelFiles() {
+		c.SetLevel(level)
+	}
 	l.Lock()
-	fl := l.fl
+	defer l.Unlock()
+	l.cancelBoost()
+	l.level.Store(int32(level))
+}
+
+// SetDebug turns debug output on or off at runtime, leaving the rest of
+// the threshold alone: enabling it lowers the level to DebugLevel if it is
+// higher, disabling it raises a DEBUG or TRACE level to InfoLevel.
+func (l *Logger) SetDebug(on bool) {
+	for _, c := range l.levelFiles() {
+		c.SetDebug(on)
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.cancelBoost()
+	switch level := l.Level(); {
+	case on && level > DebugLevel:
+		l.level.Store(int32(DebugLevel))
+	case !on && level < InfoLevel:
+		l.level.Store(int32(InfoLevel))
+	}
+}
+
+// SetTrace turns trace output on or off at runtime. Enabling it lowers the
+// level to TraceLevel; disabling it leaves debug output enabled.
+func (l *Logger) SetTrace(on bool) {
+	for _, c := range l.levelFiles() {
+		c.SetTrace(on)
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.cancelBoost()
+	switch level := l.Level(); {
+	case on:
+		l.level.Store(int32(TraceLevel))
+	case level == TraceLevel:
+		l.level.Store(int32(DebugLevel))
+	}
+}
+
+// Level returns the minimum level that is currently emitted.
+func (l *Logger) Level() Level {
+	return Level(l.level.Load())
+}
+
+func (l *Logger) enabled(level Level) bool {
+	return level >= Level(l.level.Load())
+}
+
+// BoostLevel temporarily lowers the threshold to level (e.g. DebugLevel)
+// and restores the previous level after d. A boost started while another
+// is active replaces it and restarts the timer; the level restored is the
+// one in effect before the first boost. If the level is already at least
+// as verbose, only the timer is (re)started.
+func (l *Logger) BoostLevel(level Level, d time.Duration) {
+	for _, c := range l.levelFiles() {
+		c.BoostLevel(level, d)
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	if !l.boost.active {
+		l.boost.active = true
+		l.boost.restore = l.Level()
+	}
+	if l.boost.timer != nil {
+		l.boost.timer.Stop()
+	}
+	if level < l.boost.restore {
+		l.level.Store(int32(level))
+	} else {
+		l.level.Store(int32(l.boost.restore))
+	}
+
+	l.boost.gen++
+	gen := l.boost.gen
+	l.boost.timer = time.AfterFunc(d, func() {
+		l.Lock()
+		defer l.Unlock()
+		if l.boost.gen != gen || !l.boost.active {
+			return
+		}
+		l.level.Store(int32(l.boost.restore))
+		l.cancelBoost()
+	})
+}
+
+// cancelBoost forgets any boost in progress. l must be locked.
+func (l *Logger) cancelBoost() {
+	if l.boost.timer != nil {
+		l.boost.timer.Stop()
+		l.boost.timer = nil
+	}
+	l.boost.active = false
+	l.boost.gen++
+}
+
+// ----------------------------------------------------------------------
+// Output format
+// ----------------------------------------------------------------------
+
+// SetFormat switches the output format at runtime. The swap happens under
+// the logger mutex, so each line is rendered entirely in either the old or
+// the new format.
+func (l *Logger) SetFormat(f Format) error {
+	fm, err := newFormatter(f)
+	if err != nil {
+		return err
+	}
+	for _, c := range l.levelFiles() {
+		_ = c.SetFormat(f)
+	}
+	l.Lock()
+	l.formatter = fm
 	l.Unlock()
+	return nil
+}
 
-	if fl == nil {
-		return fmt.Errorf("SetMaxNumFiles requires file logger")
+// SetLabels replaces the text of the level labels in text output, e.g.
+// "INFO" in place of "INF", rendered as "[INFO] " and keeping the level
+// colors of a colored logger. It is safe to call while logging.
+func (l *Logger) SetLabels(info, warn, error, fatal, debug, trace string) {
+	for _, c := range l.levelFiles() {
+		c.SetLabels(info, warn, error, fatal, debug, trace)
 	}
-	fl.setMaxNumFiles(max)
+	l.Lock()
+	setLabels(l, l.colored, info, warn, error, fatal, debug, trace)
+	l.Unlock()
+}
+
+// SetTimeFormat sets the time.Format layout of timestamps in text output,
+// including the file logger's own rotation messages, e.g. time.RFC3339.
+// Timestamps follow LogUTC and are omitted when the logger was created
+// without them. An empty layout restores "2006/01/02 15:04:05.000000".
+// JSON and logfmt always use RFC 3339.
+func (l *Logger) SetTimeFormat(layout string) {
+	for _, c := range l.levelFiles() {
+		c.SetTimeFormat(layout)
+	}
+	l.Lock()
+	l.timeLayout = layout
+	l.Unlock()
+}
+
+// SetInstanceID tags every record with id, e.g. a hostname or pod name.
+// It is rendered as an "instance" field in JSON and logfmt output and as a
+// "[id] " prefix in text output. An empty id removes the tag.
+func (l *Logger) SetInstanceID(id string) {
+	for _, c := range l.levelFiles() {
+		c.SetInstanceID(id)
+	}
+	l.Lock()
+	l.instance = id
+	l.Unlock()
+}
+
+// SetSampler keeps only a fraction of the records carrying field, chosen by
+// a hash of the field's value so that all records for a given value are
+// either kept or dropped together. Records without the field are always
+// emitted. An empty field disables sampling.
+func (l *Logger) SetSampler(field string, fraction float64) {
+	for _, c := range l.levelFiles() {
+		c.SetSampler(field, fraction)
+	}
+	var s *sampler
+	if field != "" {
+		s = newSampler(field, fraction)
+	}
+	l.Lock()
+	l.sampler = s
+	l.Unlock()
+}
+
+// SetOutput redirects all subsequent records to w, e.g. a NetworkSink.
+// For a file logger the log file stops receiving records but stays open
+// until Close. On a logger from NewMultiLogger or NewLeveledFileLogger it
+// redirects every destination, so w receives each record once per
+// destination that accepts it.
+func (l *Logger) SetOutput(w io.Writer) {
+	for _, c := range l.children {
+		c.SetOutput(w)
+	}
+	if l.children != nil {
+		return
+	}
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	if l.async != nil {
+		size := cap(l.async.queue)
+		l.async.close()
+		l.async = newAsyncWriter(w, size, &l.dropped)
+		w = l.async
+	}
+	l.logger.SetOutput(w)
+}
+
+// SetNonBlocking decouples logging calls from the output writer through a
+// queue of n lines. If the writer stalls (a full pipe, a hung network
+// mount) and the queue fills up, records are dropped and counted by
+// Dropped instead of blocking the caller: this trades possible log loss
+// for liveness. n <= 0 flushes the queue and restores blocking writes.
+// The destinations of a NewMultiLogger or NewLeveledFileLogger logger each
+// get their own queue.
+func (l *Logger) SetNonBlocking(n int) {
+	for _, c := range l.children {
+		c.SetNonBlocking(n)
+	}
+	if l.children != nil {
+		return
+	}
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	if l.async != nil {
+		l.logger.SetOutput(l.async.w)
+		l.async.close()
+		l.async = nil
+	}
+	if n > 0 {
+		l.async = newAsyncWriter(l.logger.Writer(), n, &l.dropped)
+		l.logger.SetOutput(l.async)
+	}
+}
+
+// SetErrorOutput copies records at or above minLevel to w in addition to
+// the regular output, e.g. warnings and errors to os.Stderr while
+// everything goes to a rotating file. Lines are rendered once, in the
+// logger's format. A nil w disables the copy. Only records from the level
+// methods (Noticef through Fatalf, Debugf and Tracef) are copied; on a
+// multi or leveled file logger the setting applies to every destination.
+func (l *Logger) SetErrorOutput(w io.Writer, minLevel Level) {
+	for _, c := range l.children {
+		c.SetErrorOutput(w, minLevel)
+	}
+	if l.children != nil {
+		return
+	}
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	l.errOut = w
+	l.errMin.Store(int32(minLevel))
+}
+
+// copiesErr reports whether a level method's record of level is copied to
+// the SetErrorOutput writer. The destinations of a multi or leveled file
+// logger decide for themselves.
+func (l *Logger) copiesErr(level Level) bool {
+	return l.children != nil || level >= Level(l.errMin.Load())
+}
+
+// Dropped returns the number of records discarded in non-blocking mode,
+// summed over the destinations of a multi or leveled file logger.
+func (l *Logger) Dropped() uint64 {
+	n := l.dropped.Load()
+	for _, c := range l.children {
+		n += c.Dropped()
+	}
+	return n
+}
+
+// SetDurationUnit renders time.Duration fields as a decimal number of unit,
+// e.g. "1230ms" for time.Millisecond. A unit of zero restores the default
+// Duration.String form ("1.23s"). Only the standard units from
+// time.Nanosecond to time.Hour are accepted.
+func (l *Logger) SetDurationUnit(unit time.Duration) error {
+	if _, ok := durationSuffixes[unit]; !ok && unit != 0 {
+		return fmt.Errorf("unsupported duration unit %v", unit)
+	}
+	for _, c := range l.levelFiles() {
+		_ = c.SetDurationUnit(unit)
+	}
+	l.Lock()
+	l.durUnit = unit
+	l.Unlock()
 	return nil
 }
 
@@ -132,6 +787,27 @@ func (l *Logger) SetMaxNumFiles(max int) error {
 // ----------------------------------------------------------------------
 
 func (l *Logger) Close() error {
+	l.SetDedup(0)
+
+	l.writeMu.Lock()
+	if l.async != nil {
+		l.logger.SetOutput(l.async.w)
+		l.async.close()
+		l.async = nil
+	}
+	sysw := l.sysw
+	l.writeMu.Unlock()
+
+	if l.children != nil {
+		var errs []error
+		for _, c := range l.children {
+			errs = append(errs, c.Close())
+		}
+		return errors.Join(errs...)
+	}
+	if sysw != nil {
+		return sysw.Close()
+	}
 	if l.fl != nil {
 		return l.fl.close()
 	}
@@ -147,56 +823,298 @@ func pidPrefix() string {
 }
 
 func setPlainLabelFormats(l *Logger) {
-	l.infoLabel = "[INF] "
-	l.debugLabel = "[DBG] "
-	l.warnLabel = "[WRN] "
-	l.errorLabel = "[ERR] "
-	l.fatalLabel = "[FTL] "
-	l.traceLabel = "[TRC] "
+	setLabels(l, false, "INF", "WRN", "ERR", "FTL", "DBG", "TRC")
 }
 
 func setColoredLabelFormats(l *Logger) {
+	setLabels(l, true, "INF", "WRN", "ERR", "FTL", "DBG", "TRC")
+}
+
+// setLabels renders the label texts as "[text] ", wrapping each text in its
+// level's color code when colored is set.
+func setLabels(l *Logger, colored bool, info, warn, error, fatal, debug, trace string) {
 	c := func(code, label string) string {
+		if !colored {
+			return "[" + label + "] "
+		}
 		return fmt.Sprintf("[\x1b[%sm%s\x1b[0m] ", code, label)
 	}
 
-	l.infoLabel = c("32", "INF")
-	l.debugLabel = c("36", "DBG")
-	l.warnLabel = c("0;93", "WRN")
-	l.errorLabel = c("31", "ERR")
-	l.fatalLabel = c("31", "FTL")
-	l.traceLabel = c("33", "TRC")
+	l.colored = colored
+	l.infoLabel = c("32", info)
+	l.debugLabel = c("36", debug)
+	l.warnLabel = c("0;93", warn)
+	l.errorLabel = c("31", error)
+	l.fatalLabel = c("31", fatal)
+	l.traceLabel = c("33", trace)
 }
 
 // ----------------------------------------------------------------------
 // Logging API
 // ----------------------------------------------------------------------
 
+func (l *Logger) label(level Level) string {
+	switch level {
+	case TraceLevel:
+		return l.traceLabel
+	case DebugLevel:
+		return l.debugLabel
+	case InfoLevel:
+		return l.infoLabel
+	case WarnLevel:
+		return l.warnLabel
+	case ErrorLevel:
+		return l.errorLabel
+	default:
+		return l.fatalLabel
+	}
+}
+
+// directLine renders a record the file logger writes itself, such as a
+// rotation notice, with the active formatter and configuration.
+func (l *Logger) directLine(dst []byte, level Level, msg string) []byte {
+	r := record{level: level, msg: msg}
+	f, _ := l.prepare(&r)
+	return f.format(dst, &r)
+}
+
+// output renders a single record with the active formatter and writes it.
+func (l *Logger) output(level Level, format string, v ...any) {
+	r := record{level: level, msg: fmt.Sprintf(format, v...), errCopy: l.copiesErr(level)}
+	if l.caller != callerOff {
+		// skip runtime.Callers, output and the level method
+		r.pc = callerPC(3)
+	}
+	l.emit(&r)
+}
+
+// callerPC returns the program counter skip frames above its caller.
+func callerPC(skip int) uintptr {
+	var pcs [1]uintptr
+	if runtime.Callers(skip+1, pcs[:]) == 0 {
+		return 0
+	}
+	return pcs[0]
+}
+
+// callerString renders pc as file:line.
+func callerString(pc uintptr, mode callerMode) string {
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	file := frame.File
+	if mode == callerBase {
+		file = filepath.Base(file)
+	}
+	return file + ":" + strconv.Itoa(frame.Line)
+}
+
+// emit completes r from the logger configuration and writes it.
+func (l *Logger) emit(r *record) {
+	level := r.level
+	if len(l.fields) > 0 {
+		// the capacity limit makes append copy instead of writing into l.fields
+		r.fields = append(l.fields[:len(l.fields):len(l.fields)], r.fields...)
+	}
+	if r.event == "" {
+		l.Lock()
+		d := l.dedup
+		l.Unlock()
+		if d != nil && !d.allow(level, r.msg, r.fields) {
+			return
+		}
+	}
+	l.write(r)
+}
+
+// write renders r and writes it to the destination.
+func (l *Logger) write(r *record) {
+	level := r.level
+	if l.children != nil {
+		children := l.children
+		if l.byLevel != nil {
+			children = nil
+			if c := l.FileFor(level); c != nil {
+				children = []*Logger{c}
+			}
+		}
+		for _, c := range children {
+			if c.enabled(level) {
+				c.emit(&record{level: level, msg: r.msg, event: r.event, fields: r.fields, pc: r.pc, errCopy: r.errCopy && c.copiesErr(level)})
+			}
+		}
+
+		// the destinations write the record; only the tail is kept here
+		l.writeMu.Lock()
+		if l.tail != nil {
+			f, _ := l.prepare(r)
+			l.buf = f.format(l.buf[:0], r)
+			l.tail.add(string(l.buf))
+		}
+		l.writeMu.Unlock()
+		return
+	}
+
+	f, s := l.prepare(r)
+	if s != nil && !s.keep(r) {
+		return
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	l.buf = f.format(l.buf[:0], r)
+	line := string(l.buf)
+	if l.tail != nil {
+		l.tail.add(line)
+	}
+	if r.errCopy && l.errOut != nil {
+		// a failing secondary output must not affect the primary one
+		l.buf = append(l.buf, '\n')
+		_, _ = l.errOut.Write(l.buf)
+	}
+	if l.sysw != nil {
+		if err := writeSyslog(l.sysw, level, line); err != nil {
+			log.Printf("failed to write to syslog: %v", err)
+		}
+		return
+	}
+	if err := l.logger.Output(0, line); err == nil && l.writesToFile() {
+		l.fl.countLine(level)
+	}
+}
+
+// prepare completes r from a snapshot of the configuration and returns the
+// formatter and sampler to apply.
+func (l *Logger) prepare(r *record) (formatter, *sampler) {
+	if l.useTime {
+		r.time = time.Now()
+		if l.utc {
+			r.time = r.time.UTC()
+		}
+	}
+
+	l.Lock()
+	f := l.formatter
+	s := l.sampler
+	r.pid = l.pid
+	r.inst = l.instance
+	r.label = l.label(r.level)
+	r.durUnit = l.durUnit
+	r.layout = l.timeLayout
+	l.Unlock()
+
+	if l.caller != callerOff && r.pc != 0 {
+		r.caller = callerString(r.pc, l.caller)
+	}
+	return f, s
+}
+
+// writesToFile reports whether records go to the log file, directly or
+// through the non-blocking queue, rather than to a writer installed with
+// SetOutput. l.writeMu must be held.
+func (l *Logger) writesToFile() bool {
+	if l.fl == nil {
+		return false
+	}
+	w := l.logger.Writer()
+	if l.async != nil {
+		w = l.async.w
+	}
+	return w == io.Writer(l.fl)
+}
+
 func (l *Logger) Noticef(format string, v ...any) {
-	l.logger.Printf(l.infoLabel+format, v...)
+	if l.enabled(InfoLevel) {
+		l.output(InfoLevel, format, v...)
+	}
 }
 
 func (l *Logger) Warnf(format string, v ...any) {
-	l.logger.Printf(l.warnLabel+format, v...)
+	if l.enabled(WarnLevel) {
+		l.output(WarnLevel, format, v...)
+	}
 }
 
 func (l *Logger) Errorf(format string, v ...any) {
-	l.logger.Printf(l.errorLabel+format, v...)
+	if l.enabled(ErrorLevel) {
+		l.output(ErrorLevel, format, v...)
+	}
 }
 
 // Fatalf logs a fatal error and terminates the program.
+// Fatalf logs at FATAL level, flushes all pending output and then calls
+// the exit function (os.Exit unless changed with SetExitFunc) with code 1.
 func (l *Logger) Fatalf(format string, v ...any) {
-	l.logger.Fatalf(l.fatalLabel+format, v...)
+	l.output(FatalLevel, format, v...)
+	l.flush()
+
+	l.Lock()
+	exit := l.exit
+	l.Unlock()
+	if exit == nil {
+		exit = os.Exit
+	}
+	exit(1)
+}
+
+// SetExitFunc replaces os.Exit as the function Fatalf calls after logging,
+// e.g. to run cleanup first or to observe the exit code in tests. A nil fn
+// restores os.Exit.
+func (l *Logger) SetExitFunc(fn func(code int)) {
+	l.Lock()
+	l.exit = fn
+	l.Unlock()
+}
+
+// flusher is implemented by outputs that hold data back, such as
+// NetworkSink.
+type flusher interface {
+	Flush() error
+}
+
+// flush writes out records queued in non-blocking mode, output buffered by
+// NewFileLoggerBuffered and data held by outputs implementing Flush.
+func (l *Logger) flush() {
+	for _, c := range l.children {
+		c.flush()
+	}
+
+	l.writeMu.Lock()
+	if a := l.async; a != nil {
+		a.close()
+		l.async = newAsyncWriter(a.w, cap(a.queue), &l.dropped)
+		l.logger.SetOutput(l.async)
+	}
+	outputs := []io.Writer{l.logger.Writer(), l.errOut}
+	if l.async != nil {
+		outputs[0] = l.async.w
+	}
+	l.writeMu.Unlock()
+
+	for _, w := range outputs {
+		if f, ok := w.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				log.Printf("failed to flush log output: %v", err)
+			}
+		}
+	}
+
+	if fl := l.fl; fl != nil {
+		fl.Lock()
+		if err := fl.flush(); err != nil {
+			log.Printf("FileLogger: flush failed: %v", err)
+		}
+		fl.Unlock()
+	}
 }
 
 func (l *Logger) Debugf(format string, v ...any) {
-	if l.debug {
-		l.logger.Printf(l.debugLabel+format, v...)
+	if l.enabled(DebugLevel) {
+		l.output(DebugLevel, format, v...)
 	}
 }
 
 func (l *Logger) Tracef(format string, v ...any) {
-	if l.trace {
-		l.logger.Printf(l.traceLabel+format, v...)
+	if l.enabled(TraceLevel) {
+		l.output(TraceLevel, format, v...)
 	}
-}
\ No newline at end of file
+}
@@ -2,9 +2,20 @@ package logger
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // --- Helpers ---
@@ -133,4 +144,1317 @@ func TestFileLoggerClose(t *testing.T) {
 	if _, err := os.Stat(fname); err != nil {
 		t.Fatalf("expected log file to exist after Close(), got error: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+// Rotation still works with fsync enabled
+func TestFileRotationSync(t *testing.T) {
+	l, fname := newTestFileLogger(t)
+
+	if err := l.SetSyncOnRotate(true); err != nil {
+		t.Fatalf("SetSyncOnRotate error: %v", err)
+	}
+	if err := l.SetSizeLimit(50); err != nil {
+		t.Fatalf("SetSizeLimit error: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		l.Noticef("hello %d", i)
+	}
+
+	matches, _ := filepath.Glob(fname + ".*")
+	if len(matches) == 0 {
+		t.Fatalf("expected rotated backup file, but none found")
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatalf("cannot read log file: %v", err)
+	}
+	if bytes.Contains(data, []byte("Unable to sync")) {
+		t.Fatalf("unexpected sync error in log: %s", data)
+	}
+
+	std := NewStdLogger(false, false, false, false, false)
+	if err := std.SetSyncOnRotate(true); err == nil {
+		t.Fatal("SetSyncOnRotate on a std logger should fail")
+	}
+}
+
+// --- Benchmarks ---
+
+// newBenchStdLogger returns a std logger writing to io.Discard in format f.
+func newBenchStdLogger(b *testing.B, f Format) *Logger {
+	b.Helper()
+	l := NewStdLogger(true, false, false, false, false)
+	l.SetOutput(io.Discard)
+	if err := l.SetFormat(f); err != nil {
+		b.Fatalf("SetFormat error: %v", err)
+	}
+	return l
+}
+
+func BenchmarkNoticef(b *testing.B) {
+	for _, f := range []Format{FormatText, FormatJSON, FormatLogfmt} {
+		b.Run(f.String(), func(b *testing.B) {
+			l := newBenchStdLogger(b, f)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.Noticef("request %d served in %s", i, "1ms")
+			}
+		})
+	}
+}
+
+func BenchmarkDebugfDisabled(b *testing.B) {
+	l := newBenchStdLogger(b, FormatText)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Debugf("request %d", i)
+	}
+}
+
+func BenchmarkFileNoticef(b *testing.B) {
+	l, err := NewFileLogger(filepath.Join(b.TempDir(), "bench.log"), true, false, false, false)
+	if err != nil {
+		b.Fatalf("NewFileLogger error: %v", err)
+	}
+	defer l.Close()
+
+	b.Run("unbuffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Noticef("request %d served", i)
+		}
+	})
+}
+
+func BenchmarkFileRotation(b *testing.B) {
+	l, err := NewFileLogger(filepath.Join(b.TempDir(), "bench.log"), true, false, false, false)
+	if err != nil {
+		b.Fatalf("NewFileLogger error: %v", err)
+	}
+	defer l.Close()
+	// every record crosses the limit, so each call rotates
+	_ = l.SetSizeLimit(1)
+	_ = l.SetMaxNumFiles(2)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Noticef("request %d served", i)
+	}
+}
+
+func TestIsBackupOf(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"app.log.2024.01.02.03.04.05.000000006", true},
+		{"app.log", false},
+		{"app.log.old", false},
+		{"app.log.old.2024.01.02.03.04.05.000000006", false},
+		{"app.log.2024.01.02.03.04.05.6", false},
+		{"app.log.2024.01.02.03.04.05.000000006.gz", true},
+		{"app.log.2024.01.02.03.04.05.000000006.gz.tmp", false},
+		{"app.logx.2024.01.02.03.04.05.000000006", false},
+	}
+	for _, tt := range tests {
+		if got := isBackupOf(tt.name, "app.log"); got != tt.want {
+			t.Errorf("isBackupOf(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Two loggers in one directory with overlapping names purge only their own
+// backups.
+func TestPurgeSharedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	var loggers []*Logger
+	for _, name := range []string{"app.log", "app.log.old"} {
+		l, err := NewFileLogger(filepath.Join(dir, name), true, false, false, false)
+		if err != nil {
+			t.Fatalf("NewFileLogger(%s) error: %v", name, err)
+		}
+		defer l.Close()
+		_ = l.SetSizeLimit(100)
+		_ = l.SetMaxNumFiles(3)
+		loggers = append(loggers, l)
+	}
+
+	var wg sync.WaitGroup
+	for _, l := range loggers {
+		wg.Add(1)
+		go func(l *Logger) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				l.Noticef("filling the log file with record %d", i)
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir error: %v", err)
+	}
+	counts := map[string]int{}
+	for _, e := range entries {
+		for _, base := range []string{"app.log", "app.log.old"} {
+			if isBackupOf(e.Name(), base) {
+				counts[base]++
+			}
+		}
+	}
+	for _, base := range []string{"app.log", "app.log.old"} {
+		if counts[base] != 2 {
+			t.Errorf("%s: %d backups kept, want 2 (entries: %d)", base, counts[base], len(entries))
+		}
+	}
+}
+
+func TestNextRotationTime(t *testing.T) {
+	now := time.Date(2024, 3, 5, 13, 20, 7, 0, time.Local)
+	tests := []struct {
+		d    time.Duration
+		want time.Time
+	}{
+		{24 * time.Hour, time.Date(2024, 3, 6, 0, 0, 0, 0, time.Local)},
+		{48 * time.Hour, time.Date(2024, 3, 7, 0, 0, 0, 0, time.Local)},
+		{time.Hour, time.Date(2024, 3, 5, 14, 0, 0, 0, time.Local)},
+		{15 * time.Minute, time.Date(2024, 3, 5, 13, 30, 0, 0, time.Local)},
+		{7 * time.Minute, now.Add(7 * time.Minute)},
+	}
+	for _, tt := range tests {
+		if got := nextRotationTime(now, tt.d); !got.Equal(tt.want) {
+			t.Errorf("nextRotationTime(%v) = %v, want %v", tt.d, got, tt.want)
+		}
+	}
+}
+
+// backups returns the rotated backups of fname.
+func backups(t *testing.T, fname string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Dir(fname))
+	if err != nil {
+		t.Fatalf("ReadDir error: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if isBackupOf(e.Name(), filepath.Base(fname)) {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+// An idle logger still rotates on schedule.
+func TestRotationIntervalIdle(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	defer l.Close()
+
+	l.Noticef("before")
+	if err := l.SetRotationInterval(50 * time.Millisecond); err != nil {
+		t.Fatalf("SetRotationInterval error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(backups(t, tmp)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no scheduled rotation without writes")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_ = l.SetRotationInterval(0)
+	n := len(backups(t, tmp))
+	time.Sleep(150 * time.Millisecond)
+	if got := len(backups(t, tmp)); got != n {
+		t.Fatalf("rotated after disabling the interval: %d -> %d backups", n, got)
+	}
+
+	std := NewStdLogger(false, false, false, false, false)
+	if err := std.SetRotationInterval(time.Hour); err == nil {
+		t.Fatal("SetRotationInterval on a std logger should fail")
+	}
+}
+
+// A due boundary rotates once, whichever of Write or the timer gets there.
+func TestRotationIntervalNoDoubleRotate(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	defer l.Close()
+
+	_ = l.SetSizeLimit(1 << 20)
+	_ = l.SetRotationInterval(time.Hour)
+
+	fl := l.fl
+	fl.Lock()
+	fl.nextRotation = time.Now().Add(-time.Second)
+	fl.Unlock()
+
+	l.Noticef("first write after the boundary")
+	l.Noticef("second write")
+	if got := len(backups(t, tmp)); got != 1 {
+		t.Fatalf("%d backups after a due boundary, want 1", got)
+	}
+
+	// the timer for the original boundary finds nothing to do
+	fl.Lock()
+	gen := fl.timerGen
+	fl.Unlock()
+	fl.rotateOnSchedule(gen)
+	if got := len(backups(t, tmp)); got != 1 {
+		t.Fatalf("%d backups after the timer fired, want 1", got)
+	}
+}
+
+func TestCompressBackups(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+
+	_ = l.SetSizeLimit(200)
+	_ = l.SetMaxNumFiles(3)
+	if err := l.SetCompressBackups(true); err != nil {
+		t.Fatalf("SetCompressBackups error: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		l.Noticef("compressible record number %d", i)
+	}
+	l.Close() // waits for pending compressions
+
+	names := backups(t, tmp)
+	if len(names) != 2 {
+		t.Fatalf("backups=%v, want 2 (max files 3 incl. the active log)", names)
+	}
+	for _, name := range names {
+		if filepath.Ext(name) != ".gz" {
+			t.Fatalf("backup %q was not compressed", name)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(filepath.Dir(tmp), names[0]))
+	if err != nil {
+		t.Fatalf("open backup: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil || !bytes.Contains(data, []byte("compressible record number")) {
+		t.Fatalf("unexpected backup content %q (err %v)", data, err)
+	}
+
+	if std := NewStdLogger(false, false, false, false, false); std.SetCompressBackups(true) == nil {
+		t.Fatal("SetCompressBackups on a std logger should fail")
+	}
+}
+
+// Close racing with rotations still waits for every compression.
+func TestCompressBackupsConcurrentClose(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	_ = l.SetSizeLimit(100)
+	_ = l.SetCompressBackups(true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			l.Noticef("record number %d racing with Close", i)
+		}
+	}()
+	time.Sleep(time.Millisecond)
+	l.Close()
+	<-done
+
+	entries, err := os.ReadDir(filepath.Dir(tmp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Fatalf("compression still running after Close: %q", e.Name())
+		}
+	}
+	for _, name := range backups(t, tmp) {
+		if filepath.Ext(name) != ".gz" {
+			t.Fatalf("backup %q not compressed when Close returned", name)
+		}
+	}
+}
+
+func TestCompressBackupFailure(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	defer l.Close()
+
+	// a directory in the way of the temporary file makes gzip fail
+	bak := tmp + "." + time.Now().Format(backupStampLayout)
+	if err := os.WriteFile(bak, []byte("plain\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(bak+".gz.tmp", 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	l.fl.compressing.Add(1)
+	l.fl.compressBackup(bak)
+
+	if _, err := os.Stat(bak); err != nil {
+		t.Fatalf("plain backup removed after failed compression: %v", err)
+	}
+	if _, err := os.Stat(bak + ".gz"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected compressed backup after failure: %v", err)
+	}
+	data, _ := os.ReadFile(tmp)
+	if !bytes.Contains(data, []byte("Unable to compress backup log file")) {
+		t.Fatalf("compression failure not logged: %q", data)
+	}
+}
+
+func TestSetMaxAge(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	defer l.Close()
+
+	now := time.Now()
+	old := tmp + "." + now.Add(-48*time.Hour).Format(backupStampLayout)
+	oldGz := tmp + "." + now.Add(-47*time.Hour).Format(backupStampLayout) + ".gz"
+	recent := tmp + "." + now.Add(-time.Hour).Format(backupStampLayout)
+	unrelated := tmp + ".old." + now.Add(-48*time.Hour).Format(backupStampLayout)
+	for _, name := range []string{old, oldGz, recent, unrelated} {
+		if err := os.WriteFile(name, []byte("backup\n"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.SetMaxAge(24 * time.Hour); err != nil {
+		t.Fatalf("SetMaxAge error: %v", err)
+	}
+	_ = l.SetSizeLimit(100)
+	for i := 0; i < 3; i++ {
+		l.Noticef("record number %d to force rotation", i)
+	}
+
+	for _, name := range []string{old, oldGz} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Fatalf("expired backup %q not purged (err %v)", name, err)
+		}
+	}
+	for _, name := range []string{recent, unrelated} {
+		if _, err := os.Stat(name); err != nil {
+			t.Fatalf("backup %q should be kept: %v", name, err)
+		}
+	}
+
+	// the count limit still applies on top of the age limit
+	_ = l.SetMaxNumFiles(2)
+	for i := 0; i < 3; i++ {
+		l.Noticef("record number %d to force rotation", i)
+	}
+	if names := backups(t, tmp); len(names) != 1 {
+		t.Fatalf("backups=%v, want 1 (max files 2 incl. the active log)", names)
+	}
+
+	if std := NewStdLogger(false, false, false, false, false); std.SetMaxAge(time.Hour) == nil {
+		t.Fatal("SetMaxAge on a std logger should fail")
+	}
+}
+
+func TestRotationHook(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	defer l.Close()
+
+	type rotation struct{ old, bak string }
+	got := make(chan rotation, 16)
+	var panicked atomic.Bool
+	if err := l.SetRotationHook(func(oldPath, backupPath string) {
+		got <- rotation{oldPath, backupPath}
+		if panicked.CompareAndSwap(false, true) {
+			panic("hook failure must not reach the writer")
+		}
+	}); err != nil {
+		t.Fatalf("SetRotationHook error: %v", err)
+	}
+	_ = l.SetSizeLimit(100)
+	for i := 0; i < 10; i++ {
+		l.Noticef("record number %d to force rotation", i)
+	}
+
+	var r rotation
+	select {
+	case r = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rotation hook not called")
+	}
+	if r.old != tmp {
+		t.Fatalf("oldPath=%q, want %q", r.old, tmp)
+	}
+	if filepath.Dir(r.bak) != filepath.Dir(tmp) || !isBackupOf(filepath.Base(r.bak), filepath.Base(tmp)) {
+		t.Fatalf("backupPath=%q is not a backup of %q", r.bak, tmp)
+	}
+	if _, err := os.Stat(r.bak); err != nil {
+		t.Fatalf("backup %q missing: %v", r.bak, err)
+	}
+
+	// the logger keeps working after the hook panicked
+	l.Noticef("after hook panic")
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rotation hook not called again after a panic")
+	}
+
+	if std := NewStdLogger(false, false, false, false, false); std.SetRotationHook(nil) == nil {
+		t.Fatal("SetRotationHook on a std logger should fail")
+	}
+}
+
+// Simulates logrotate: rename the file, then reopen.
+func TestReopenLogFile(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	defer l.Close()
+
+	l.Noticef("before rotate")
+	moved := tmp + ".1"
+	if err := os.Rename(tmp, moved); err != nil {
+		t.Fatal(err)
+	}
+	l.Noticef("still old inode")
+
+	if err := l.ReopenLogFile(); err != nil {
+		t.Fatalf("ReopenLogFile error: %v", err)
+	}
+	l.Noticef("after reopen")
+
+	old, _ := os.ReadFile(moved)
+	cur, _ := os.ReadFile(tmp)
+	if !bytes.Contains(old, []byte("still old inode")) || bytes.Contains(old, []byte("after reopen")) {
+		t.Fatalf("unexpected content in renamed file: %q", old)
+	}
+	if !bytes.Contains(cur, []byte("after reopen")) || bytes.Contains(cur, []byte("before rotate")) {
+		t.Fatalf("unexpected content in reopened file: %q", cur)
+	}
+	if got := l.fl.currentSize; got != int64(len(cur)) {
+		t.Fatalf("currentSize=%d, want %d", got, len(cur))
+	}
+
+	std := NewStdLogger(false, false, false, false, false)
+	if err := std.ReopenLogFile(); err == nil {
+		t.Fatal("ReopenLogFile on a std logger should fail")
+	}
+}
+
+func TestReopenLogFileConcurrent(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	defer l.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				l.Noticef("line %d", j)
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := l.ReopenLogFile(); err != nil {
+			t.Errorf("ReopenLogFile error: %v", err)
+		}
+	}
+	wg.Wait()
+
+	data, _ := os.ReadFile(tmp)
+	if n := bytes.Count(data, []byte("\n")); n != 800 {
+		t.Fatalf("got %d lines, want 800", n)
+	}
+}
+
+func newTestBufferedLogger(t *testing.T, size int, interval time.Duration) (*Logger, string) {
+	t.Helper()
+	tmp := filepath.Join(t.TempDir(), "buffered.log")
+	l, err := NewFileLoggerBuffered(tmp, size, interval, false, false, false, false)
+	if err != nil {
+		t.Fatalf("NewFileLoggerBuffered error: %v", err)
+	}
+	return l, tmp
+}
+
+// Nothing reaches the file before Close, and nothing is lost on Close.
+func TestBufferedFlushOnClose(t *testing.T) {
+	l, tmp := newTestBufferedLogger(t, 0, 0)
+
+	for i := 0; i < 100; i++ {
+		l.Noticef("buffered %d", i)
+	}
+	if data, _ := os.ReadFile(tmp); len(data) != 0 {
+		t.Fatalf("data written before flush: %d bytes", len(data))
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	data, _ := os.ReadFile(tmp)
+	if n := bytes.Count(data, []byte("\n")); n != 100 {
+		t.Fatalf("got %d lines after Close, want 100", n)
+	}
+	if !bytes.HasSuffix(data, []byte("[INF] buffered 99\n")) {
+		t.Fatalf("last line missing: %q", data[len(data)-40:])
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("second Close error: %v", err)
+	}
+}
+
+func TestBufferedFlushWhenFull(t *testing.T) {
+	l, tmp := newTestBufferedLogger(t, 64, 0)
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		l.Noticef("fill the buffer %d", i)
+	}
+	data, _ := os.ReadFile(tmp)
+	if len(data) < 64 {
+		t.Fatalf("full buffer not flushed: %d bytes on disk", len(data))
+	}
+}
+
+func TestBufferedFlushInterval(t *testing.T) {
+	l, tmp := newTestBufferedLogger(t, 0, 10*time.Millisecond)
+	defer l.Close()
+
+	l.Noticef("flushed by the timer")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(tmp)
+		if bytes.Contains(data, []byte("flushed by the timer")) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("periodic flush did not happen")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Buffered bytes are flushed into the file being rotated, so no line is
+// lost or moved across a rotation.
+func TestBufferedRotation(t *testing.T) {
+	l, tmp := newTestBufferedLogger(t, 0, 0)
+	_ = l.SetSizeLimit(512)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				l.Noticef("record %d", j)
+			}
+		}()
+	}
+	wg.Wait()
+	l.Close()
+
+	names := append(backups(t, tmp), filepath.Base(tmp))
+	if len(names) < 2 {
+		t.Fatalf("expected rotations, got files %v", names)
+	}
+	var records int
+	for _, name := range names {
+		data, _ := os.ReadFile(filepath.Join(filepath.Dir(tmp), name))
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			t.Fatalf("%s ends with a partial line", name)
+		}
+		records += bytes.Count(data, []byte("] record "))
+	}
+	if records != 200 {
+		t.Fatalf("got %d records across files, want 200", records)
+	}
+}
+
+// failingWriter rejects every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestMultiLogger(t *testing.T) {
+	fileLog, tmp := newTestFileLogger(t)
+
+	mem := NewStdLogger(false, false, false, true, false)
+	var buf bytes.Buffer
+	mem.SetOutput(&buf)
+
+	broken := NewStdLogger(false, false, false, false, false)
+	broken.SetOutput(failingWriter{})
+
+	l := NewMultiLogger(broken, mem, fileLog)
+	l.With("id", 7).Noticef("fan out")
+	l.Debugf("file only")
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	// colored labels on the in-memory logger, plain labels in the file
+	assertContains(t, &buf, "fan out id=7")
+	if !bytes.Contains(buf.Bytes(), []byte("\x1b[")) {
+		t.Fatalf("expected colored label, got %q", buf.String())
+	}
+	if bytes.Contains(buf.Bytes(), []byte("file only")) {
+		t.Fatalf("debug record ignored the in-memory logger's level: %q", buf.String())
+	}
+
+	data, _ := os.ReadFile(tmp)
+	if !bytes.Contains(data, []byte("[INF] fan out id=7")) || !bytes.Contains(data, []byte("[DBG] file only")) {
+		t.Fatalf("unexpected file content %q", data)
+	}
+}
+
+// callerLine returns the line number of its call site.
+func callerLine() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
+
+func TestLogCaller(t *testing.T) {
+	l := NewStdLogger(false, false, false, false, false, LogCaller(true))
+	var buf bytes.Buffer
+	l.SetOutput(&buf)
+
+	line := callerLine() + 1
+	l.Noticef("here")
+	assertContains(t, &buf, fmt.Sprintf("[INF] log_test.go:%d: here", line))
+
+	// derived loggers and events report their own call sites too
+	buf.Reset()
+	line = callerLine() + 1
+	l.With("k", 1).Warnf("derived")
+	assertContains(t, &buf, fmt.Sprintf("[WRN] log_test.go:%d: derived k=1", line))
+
+	buf.Reset()
+	line = callerLine() + 1
+	l.Event("evt")
+	assertContains(t, &buf, fmt.Sprintf("log_test.go:%d: event=evt", line))
+
+	// the same depth applies to file loggers
+	tmp := filepath.Join(t.TempDir(), "caller.log")
+	fileLog, err := NewFileLogger(tmp, false, false, false, false, LogCallerFullPath(true))
+	if err != nil {
+		t.Fatalf("NewFileLogger error: %v", err)
+	}
+	line = callerLine() + 1
+	fileLog.Errorf("to file")
+	fileLog.Close()
+	_, self, _, _ := runtime.Caller(0)
+	data, _ := os.ReadFile(tmp)
+	if want := fmt.Sprintf("[ERR] %s:%d: to file", self, line); !bytes.Contains(data, []byte(want)) {
+		t.Fatalf("file content %q does not contain %q", data, want)
+	}
+
+	// off by default
+	plain, pbuf := newTestStdLogger(t)
+	plain.Noticef("no caller")
+	if bytes.Contains(pbuf.Bytes(), []byte("log_test.go")) {
+		t.Fatalf("caller logged without LogCaller: %q", pbuf.String())
+	}
+}
+
+func TestLogFilePerms(t *testing.T) {
+	// modes are compared as subsets, since the umask may clear bits
+	checkMode := func(t *testing.T, path string, want os.FileMode) {
+		t.Helper()
+		st, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat %s: %v", path, err)
+		}
+		if got := st.Mode().Perm(); got&^want != 0 || got&0o600 != 0o600 {
+			t.Fatalf("%s: mode %v, want a subset of %v", filepath.Base(path), got, want)
+		}
+	}
+
+	tmp := filepath.Join(t.TempDir(), "secret.log")
+	l, err := NewFileLogger(tmp, false, false, false, false, LogFilePerms(0o600))
+	if err != nil {
+		t.Fatalf("NewFileLogger error: %v", err)
+	}
+	_ = l.SetSizeLimit(64)
+	for i := 0; i < 10; i++ {
+		l.Noticef("rotate with restricted perms %d", i)
+	}
+	_ = l.ReopenLogFile()
+	l.Close()
+
+	checkMode(t, tmp, 0o600)
+	names := backups(t, tmp)
+	if len(names) == 0 {
+		t.Fatal("expected rotated backups")
+	}
+	for _, name := range names {
+		checkMode(t, filepath.Join(filepath.Dir(tmp), name), 0o600)
+	}
+
+	// default stays 0640
+	l2, path := newTestFileLogger(t)
+	l2.Close()
+	checkMode(t, path, defaultLogPerms)
+}
+
+func TestSetTimeFormat(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "time.log")
+	l, err := NewFileLogger(tmp, true, false, false, false, LogUTC(true))
+	if err != nil {
+		t.Fatalf("NewFileLogger error: %v", err)
+	}
+	l.SetTimeFormat(time.RFC3339)
+	_ = l.SetSizeLimit(1)
+	l.Noticef("rfc3339") // rotates, so logDirect writes the notice
+	l.Close()
+
+	data, _ := os.ReadFile(tmp)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) == 0 || !strings.Contains(lines[0], "Rotated log") {
+		t.Fatalf("expected the rotation notice, got %q", data)
+	}
+	for _, name := range append(backups(t, tmp), filepath.Base(tmp)) {
+		data, _ := os.ReadFile(filepath.Join(filepath.Dir(tmp), name))
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			stamp, _, _ := strings.Cut(line, " ")
+			ts, err := time.Parse(time.RFC3339, stamp)
+			if err != nil || ts.Location() != time.UTC {
+				t.Fatalf("line %q does not start with a UTC RFC 3339 stamp (%v)", line, err)
+			}
+		}
+	}
+}
+
+// A layout longer than logDirect's stack buffer must not corrupt the line.
+func TestSetTimeFormatLongLayout(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	layout := strings.Repeat("x", 300) + " 2006"
+	l.SetTimeFormat(layout)
+	_ = l.SetSizeLimit(1)
+	l.Noticef("long")
+	l.Close()
+
+	data, _ := os.ReadFile(tmp)
+	want := strings.Repeat("x", 300) + " " + strconv.Itoa(time.Now().Year()) + " [INF] Rotated log"
+	if !strings.HasPrefix(string(data), want) {
+		t.Fatalf("unexpected rotation notice %q", data)
+	}
+}
+
+func TestSetTimeFormatNoTimestamp(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "notime.log")
+	l, err := NewFileLogger(tmp, false, false, false, false)
+	if err != nil {
+		t.Fatalf("NewFileLogger error: %v", err)
+	}
+	l.SetTimeFormat(time.RFC3339)
+	_ = l.SetSizeLimit(1)
+	l.Noticef("bare")
+	l.Close()
+
+	data, _ := os.ReadFile(tmp)
+	if !strings.HasPrefix(string(data), "[INF] Rotated log") {
+		t.Fatalf("timestamp written without useTime: %q", data)
+	}
+}
+
+func TestSetLabels(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.SetLabels("INFO", "WARN", "ERROR", "FATAL", "DEBUG", "TRACE")
+	l.Noticef("n")
+	l.Warnf("w")
+	l.Errorf("e")
+	l.Debugf("d")
+	l.Tracef("t")
+	for _, want := range []string{"[INFO] n", "[WARN] w", "[ERROR] e", "[DEBUG] d", "[TRACE] t"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("missing %q in %q", want, buf.String())
+		}
+	}
+
+	colored := NewStdLogger(false, false, false, true, false)
+	var cbuf bytes.Buffer
+	colored.SetOutput(&cbuf)
+	colored.SetLabels("INFO", "WARN", "ERROR", "FATAL", "DEBUG", "TRACE")
+	colored.Warnf("w")
+	if want := "[\x1b[0;93mWARN\x1b[0m] w\n"; cbuf.String() != want {
+		t.Fatalf("colored label: got %q, want %q", cbuf.String(), want)
+	}
+}
+
+// Labels can change while other goroutines log.
+func TestSetLabelsConcurrent(t *testing.T) {
+	l := NewStdLogger(false, false, false, false, false)
+	var buf lockedBuffer
+	l.SetOutput(&buf)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.Noticef("msg")
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		l.SetLabels("I"+strconv.Itoa(i), "W", "E", "F", "D", "T")
+	}
+	wg.Wait()
+}
+
+func TestLogColorAuto(t *testing.T) {
+	defer func(f func() bool) { stderrIsTerminal = f }(stderrIsTerminal)
+
+	for _, tty := range []bool{false, true} {
+		stderrIsTerminal = func() bool { return tty }
+		// the colors argument is overridden either way
+		l := NewStdLogger(false, false, false, !tty, false, LogColorAuto(true))
+		var buf bytes.Buffer
+		l.SetOutput(&buf)
+		l.Errorf("auto")
+		if got := strings.Contains(buf.String(), "\x1b["); got != tty {
+			t.Fatalf("tty=%v: escape codes=%v in %q", tty, got, buf.String())
+		}
+	}
+}
+
+// Character devices that are not terminals do not get colors.
+func TestIsTerminal(t *testing.T) {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("cannot open %s: %v", os.DevNull, err)
+	}
+	defer f.Close()
+	if isTerminal(f.Fd()) {
+		t.Fatalf("%s reported as a terminal", os.DevNull)
+	}
+}
+
+// exitCode is the panic value used to stop a test at Fatalf.
+type exitCode int
+
+// catchExit runs fn and returns the code passed to the exit function.
+func catchExit(t *testing.T, l *Logger, fn func()) (code int) {
+	t.Helper()
+	l.SetExitFunc(func(c int) { panic(exitCode(c)) })
+	defer func() {
+		c, ok := recover().(exitCode)
+		if !ok {
+			t.Fatal("exit function was not called")
+		}
+		code = int(c)
+	}()
+	fn()
+	return -1
+}
+
+func TestSetExitFunc(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+
+	if code := catchExit(t, l, func() { l.Fatalf("giving up: %d", 42) }); code != 1 {
+		t.Fatalf("exit code=%d, want 1", code)
+	}
+	assertContains(t, buf, "[FTL] giving up: 42")
+}
+
+// Buffered and non-blocking output is written before the exit function runs.
+func TestFatalfFlushes(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "fatal.log")
+	l, err := NewFileLoggerBuffered(tmp, 0, 0, false, false, false, false)
+	if err != nil {
+		t.Fatalf("NewFileLoggerBuffered error: %v", err)
+	}
+	defer l.Close()
+	l.SetNonBlocking(64)
+
+	var onDisk []byte
+	l.SetExitFunc(func(int) { onDisk, _ = os.ReadFile(tmp) })
+	l.Noticef("before")
+	l.Fatalf("fatal")
+
+	if string(onDisk) != "[INF] before\n[FTL] fatal\n" {
+		t.Fatalf("file content at exit %q", onDisk)
+	}
+
+	// logging keeps working in non-blocking mode afterwards
+	l.Noticef("after")
+	l.Close()
+	if data, _ := os.ReadFile(tmp); !bytes.HasSuffix(data, []byte("[INF] after\n")) {
+		t.Fatalf("record after Fatalf lost: %q", data)
+	}
+}
+
+func TestSetErrorOutput(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	var stderr bytes.Buffer
+	l.SetErrorOutput(&stderr, WarnLevel)
+
+	l.Noticef("routine")
+	l.Errorf("broken")
+	l.Close()
+
+	if got := stderr.String(); !strings.HasSuffix(got, "[ERR] broken\n") || strings.Contains(got, "routine") {
+		t.Fatalf("unexpected secondary output %q", got)
+	}
+	data, _ := os.ReadFile(tmp)
+	if !bytes.Contains(data, []byte("[INF] routine")) || !bytes.Contains(data, []byte("[ERR] broken")) {
+		t.Fatalf("primary output incomplete: %q", data)
+	}
+
+	// a failing secondary writer does not block the primary output
+	std, buf := newTestStdLogger(t)
+	std.SetErrorOutput(failingWriter{}, ErrorLevel)
+	std.Errorf("still logged")
+	assertContains(t, buf, "[ERR] still logged")
+
+	// only the level methods copy: events and slog records do not
+	var copied bytes.Buffer
+	std.SetErrorOutput(&copied, InfoLevel)
+	std.Event("ready", "port", 80)
+	slog.New(NewSlogHandler(std)).Warn("via slog")
+	std.Warnf("via Warnf")
+	if got := copied.String(); !strings.HasSuffix(got, "[WRN] via Warnf\n") || strings.Contains(got, "ready") || strings.Contains(got, "slog") {
+		t.Fatalf("unexpected secondary output %q", got)
+	}
+
+	// multi loggers copy through their destinations
+	copied.Reset()
+	a, _ := newTestStdLogger(t)
+	multi := NewMultiLogger(a)
+	multi.SetErrorOutput(&copied, ErrorLevel)
+	multi.Warnf("not copied")
+	multi.Errorf("copied")
+	if got := copied.String(); !strings.HasSuffix(got, "[ERR] copied\n") || strings.Contains(got, "not copied") {
+		t.Fatalf("unexpected secondary output from multi logger %q", got)
+	}
+}
+
+// Rotation decisions are traced at debug level when diagnostics are on
+func TestRotationDiagnostics(t *testing.T) {
+	l, fname := newTestFileLogger(t)
+
+	if err := l.SetRotationDiagnostics(true); err != nil {
+		t.Fatalf("SetRotationDiagnostics error: %v", err)
+	}
+	if err := l.SetSizeLimit(1000); err != nil {
+		t.Fatalf("SetSizeLimit error: %v", err)
+	}
+	l.Noticef("first")
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatalf("cannot read log file: %v", err)
+	}
+	if !bytes.Contains(data, []byte("[DBG] Rotation check: size=")) ||
+		!bytes.Contains(data, []byte("limit=1000 rotate=false")) {
+		t.Fatalf("missing rotation diagnostics: %s", data)
+	}
+
+	// silent without debug
+	l2, fname2 := newTestFileLogger(t)
+	l2.SetLevel(InfoLevel)
+	_ = l2.SetRotationDiagnostics(true)
+	_ = l2.SetSizeLimit(1000)
+	l2.Noticef("first")
+	data, _ = os.ReadFile(fname2)
+	if bytes.Contains(data, []byte("Rotation check")) {
+		t.Fatalf("diagnostics logged with debug disabled: %s", data)
+	}
+
+	// rendered with the logger's format
+	l3, fname3 := newTestFileLogger(t)
+	_ = l3.SetFormat(FormatJSON)
+	_ = l3.SetRotationDiagnostics(true)
+	_ = l3.SetSizeLimit(1000)
+	l3.Noticef("first")
+	data, _ = os.ReadFile(fname3)
+	if !bytes.Contains(data, []byte(`"level":"DEBUG","msg":"Rotation check: size=`)) {
+		t.Fatalf("diagnostics not rendered as JSON: %s", data)
+	}
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		if !json.Valid(line) {
+			t.Fatalf("invalid JSON line %q", line)
+		}
+	}
+}
+
+// SetLevel filters records below the threshold
+func TestSetLevel(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+
+	l.SetLevel(WarnLevel)
+	if l.Level() != WarnLevel {
+		t.Fatalf("Level=%v, want WARN", l.Level())
+	}
+	l.Noticef("hidden")
+	l.Debugf("hidden")
+	l.Warnf("shown")
+	if bytes.Contains(buf.Bytes(), []byte("hidden")) {
+		t.Fatalf("records below WARN were emitted: %q", buf.String())
+	}
+	assertContains(t, buf, "[WRN] shown")
+}
+
+func TestSetDebugTrace(t *testing.T) {
+	l := NewStdLogger(false, false, false, false, false)
+
+	steps := []struct {
+		name string
+		set  func()
+		want Level
+	}{
+		{"SetDebug(true)", func() { l.SetDebug(true) }, DebugLevel},
+		{"SetTrace(true)", func() { l.SetTrace(true) }, TraceLevel},
+		{"SetTrace(false)", func() { l.SetTrace(false) }, DebugLevel},
+		{"SetDebug(false)", func() { l.SetDebug(false) }, InfoLevel},
+		{"SetTrace(false) at info", func() { l.SetTrace(false) }, InfoLevel},
+		{"SetTrace(true) again", func() { l.SetTrace(true) }, TraceLevel},
+		{"SetDebug(false) from trace", func() { l.SetDebug(false) }, InfoLevel},
+	}
+	for _, s := range steps {
+		s.set()
+		if got := l.Level(); got != s.want {
+			t.Fatalf("after %s: Level=%v, want %v", s.name, got, s.want)
+		}
+	}
+
+	// an error-only threshold is not lowered by disabling debug
+	l.SetLevel(ErrorLevel)
+	l.SetDebug(false)
+	if l.Level() != ErrorLevel {
+		t.Fatalf("SetDebug(false) changed ERROR threshold to %v", l.Level())
+	}
+}
+
+// Toggling verbosity while other goroutines log must be race free.
+func TestSetDebugConcurrent(t *testing.T) {
+	l := NewStdLogger(true, false, false, false, false)
+	l.SetOutput(io.Discard)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					l.Debugf("debug")
+					l.Tracef("trace")
+					l.Noticef("info")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 500; i++ {
+		l.SetDebug(i%2 == 0)
+		l.SetTrace(i%3 == 0)
+	}
+	close(done)
+	wg.Wait()
+}
+
+// BoostLevel raises verbosity and restores it after the duration
+func TestBoostLevel(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.SetLevel(InfoLevel)
+
+	l.BoostLevel(DebugLevel, 50*time.Millisecond)
+	if l.Level() != DebugLevel {
+		t.Fatalf("Level during boost=%v, want DEBUG", l.Level())
+	}
+	l.Debugf("boosted")
+	assertContains(t, buf, "[DBG] boosted")
+
+	// overlapping boost: latest wins and restarts the timer, but the
+	// original level is what gets restored
+	l.BoostLevel(TraceLevel, 100*time.Millisecond)
+	if l.Level() != TraceLevel {
+		t.Fatalf("Level during second boost=%v, want TRACE", l.Level())
+	}
+	time.Sleep(70 * time.Millisecond)
+	if l.Level() != TraceLevel {
+		t.Fatal("first boost timer restored the level early")
+	}
+	waitForLevel(t, l, InfoLevel)
+}
+
+// An explicit SetLevel during a boost is not undone by the boost timer
+func TestBoostLevelSetLevelWins(t *testing.T) {
+	l, _ := newTestStdLogger(t)
+	l.SetLevel(InfoLevel)
+
+	l.BoostLevel(TraceLevel, 30*time.Millisecond)
+	l.SetLevel(ErrorLevel)
+	time.Sleep(80 * time.Millisecond)
+	if l.Level() != ErrorLevel {
+		t.Fatalf("Level=%v after boost expiry, want ERROR", l.Level())
+	}
+
+	// a new boost after that restores ERROR
+	l.BoostLevel(DebugLevel, 10*time.Millisecond)
+	waitForLevel(t, l, ErrorLevel)
+}
+
+func waitForLevel(t *testing.T, l *Logger, want Level) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for l.Level() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Level=%v, want %v", l.Level(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Line counts are per level and survive rotation
+func TestLineCounts(t *testing.T) {
+	l, _ := newTestFileLogger(t)
+
+	if err := l.SetSizeLimit(100); err != nil {
+		t.Fatalf("SetSizeLimit error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		l.Noticef("info %d", i)
+	}
+	l.Errorf("boom")
+	l.Debugf("dbg")
+
+	lc, err := l.LineCounts()
+	if err != nil {
+		t.Fatalf("LineCounts error: %v", err)
+	}
+	if lc.ByLevel[ErrorLevel] != 1 || lc.ByLevel[DebugLevel] != 1 {
+		t.Fatalf("unexpected per-level counts: %v", lc.ByLevel)
+	}
+	// 10 records plus at least one "Rotated log" notice
+	if lc.ByLevel[InfoLevel] <= 10 {
+		t.Fatalf("info count=%d, want > 10 (records plus rotation notices)", lc.ByLevel[InfoLevel])
+	}
+	if lc.Total != lc.ByLevel[InfoLevel]+2 {
+		t.Fatalf("Total=%d does not match per-level sum", lc.Total)
+	}
+
+	if err := l.ResetLineCounts(); err != nil {
+		t.Fatalf("ResetLineCounts error: %v", err)
+	}
+	if lc, _ = l.LineCounts(); lc.Total != 0 {
+		t.Fatalf("Total after reset=%d, want 0", lc.Total)
+	}
+
+	std := NewStdLogger(false, false, false, false, false)
+	if _, err := std.LineCounts(); err == nil {
+		t.Fatal("LineCounts on a std logger should fail")
+	}
+}
+
+func TestLineCountsNonBlockingDrops(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	_ = l.SetSizeLimit(1 << 20) // routes writes through fl's lock
+	l.SetNonBlocking(4)
+
+	l.fl.Lock() // stall the file so the queue fills up
+	for i := 0; i < 100; i++ {
+		l.Noticef("line %d", i)
+	}
+	l.fl.Unlock()
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	lc, _ := l.LineCounts()
+	data, _ := os.ReadFile(tmp)
+	written := uint64(bytes.Count(data, []byte("\n")))
+	if l.Dropped() == 0 || lc.Total != written || lc.Total+l.Dropped() != 100 {
+		t.Fatalf("Total=%d written=%d dropped=%d", lc.Total, written, l.Dropped())
+	}
+
+	// records redirected away from the file are not counted
+	f, _ := newTestFileLogger(t)
+	defer f.Close()
+	f.SetOutput(io.Discard)
+	f.Noticef("elsewhere")
+	if lc, _ := f.LineCounts(); lc.Total != 0 {
+		t.Fatalf("Total=%d after SetOutput, want 0", lc.Total)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for use as a concurrent log output.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestConcurrentConfigAndLogging(t *testing.T) {
+	l := NewStdLogger(true, true, true, false, false)
+	outs := []*lockedBuffer{{}, {}}
+	l.SetOutput(outs[0])
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				l.Noticef("line %d", j)
+				l.Debugf("debug %d", j)
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		l.SetLevel(Level(j % 3))
+		l.SetOutput(outs[j%2])
+		_ = l.SetFormat(Format(j % 3))
+		l.SetTailSize(j % 8)
+	}
+	wg.Wait()
+
+	// Info is enabled at every level set above, so no notice may be lost.
+	var n int
+	for _, out := range outs {
+		n += bytes.Count(out.buf.Bytes(), []byte("line "))
+	}
+	if n != 800 {
+		t.Fatalf("got %d notices across outputs, want 800", n)
+	}
+}
+
+func TestSetSizeLimitBelowCurrentSize(t *testing.T) {
+	l, tmp := newTestFileLogger(t)
+	defer l.Close()
+
+	for i := 0; i < 20; i++ {
+		l.Noticef("filling the log file %d", i)
+	}
+
+	// Used to deadlock: the rotation notice was logged with fl locked.
+	done := make(chan struct{})
+	go func() {
+		l.SetSizeLimit(16)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetSizeLimit below the current size deadlocked")
+	}
+
+	matches, _ := filepath.Glob(tmp + ".*")
+	if len(matches) == 0 {
+		t.Fatal("expected a rotated backup after lowering the limit")
+	}
+}
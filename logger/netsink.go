@@ -0,0 +1,243 @@
+package logger
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultNetSinkBuffer is used when NewNetworkSink is given bufBytes <= 0.
+	defaultNetSinkBuffer = 1 << 20
+
+	netSinkDialTimeout = 5 * time.Second
+	netSinkMinBackoff  = 100 * time.Millisecond
+	netSinkMaxBackoff  = 10 * time.Second
+)
+
+// netSinkWriteTimeout bounds each write of queued lines, so that a
+// collector that stops reading cannot block the sink, and Close, forever.
+// Tests shorten it.
+var netSinkWriteTimeout = 5 * time.Second
+
+var errSinkClosed = errors.New("network sink closed")
+
+var errSinkFlushTimeout = errors.New("network sink flush timed out")
+
+// NetworkSink is an io.WriteCloser that ships log lines to a remote
+// collector. Writes only queue the data; a background goroutine owns the
+// connection, writes the queue out and redials with exponential backoff
+// when the connection fails. While disconnected, at most bufBytes are kept
+// and the oldest lines are dropped first.
+type NetworkSink struct {
+	network string
+	addr    string
+	maxBuf  int
+	dial    func(network, addr string) (net.Conn, error)
+
+	mu     sync.Mutex
+	queue  [][]byte
+	queued int  // bytes held in queue
+	busy   bool // a batch taken from queue is being delivered
+	closed bool
+
+	dropped atomic.Uint64
+	wake    chan struct{}
+	done    chan struct{}
+	exited  chan struct{}
+}
+
+// NewNetworkSink returns a sink writing to addr on the given network
+// (e.g. "tcp"). The connection is established lazily in the background,
+// so an unreachable collector never blocks the caller. Attach it with
+// Logger.SetOutput.
+func NewNetworkSink(network, addr string, bufBytes int) *NetworkSink {
+	return newNetworkSink(network, addr, bufBytes, func(network, addr string) (net.Conn, error) {
+		return net.DialTimeout(network, addr, netSinkDialTimeout)
+	})
+}
+
+func newNetworkSink(network, addr string, bufBytes int, dial func(string, string) (net.Conn, error)) *NetworkSink {
+	if bufBytes <= 0 {
+		bufBytes = defaultNetSinkBuffer
+	}
+	s := &NetworkSink{
+		network: network,
+		addr:    addr,
+		maxBuf:  bufBytes,
+		dial:    dial,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write queues a copy of p for delivery. It never blocks on the network.
+func (s *NetworkSink) Write(p []byte) (int, error) {
+	line := append([]byte(nil), p...)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return 0, errSinkClosed
+	}
+	s.push(line)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// Dropped returns the number of lines discarded because the buffer was full.
+func (s *NetworkSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Flush waits until every queued line has been written to the collector,
+// for at most the write timeout, and reports errSinkFlushTimeout if lines
+// are still pending then. Lines written concurrently may be left queued.
+func (s *NetworkSink) Flush() error {
+	deadline := time.Now().Add(netSinkWriteTimeout)
+	for {
+		s.mu.Lock()
+		idle := s.closed || (len(s.queue) == 0 && !s.busy)
+		s.mu.Unlock()
+		if idle {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errSinkFlushTimeout
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Close stops the background goroutine after a final attempt to deliver
+// queued lines over an existing connection, and closes that connection.
+func (s *NetworkSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.done)
+	<-s.exited
+	return nil
+}
+
+// push appends line, dropping the oldest lines to stay within maxBuf.
+// s.mu must be held.
+func (s *NetworkSink) push(line []byte) {
+	for len(s.queue) > 0 && s.queued+len(line) > s.maxBuf {
+		s.queued -= len(s.queue[0])
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.dropped.Add(1)
+	}
+	s.queue = append(s.queue, line)
+	s.queued += len(line)
+}
+
+// take removes and returns all queued lines. The sink stays busy until a
+// take finds the queue empty.
+func (s *NetworkSink) take() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := s.queue
+	s.queue = nil
+	s.queued = 0
+	s.busy = len(batch) > 0
+	return batch
+}
+
+// requeue puts undelivered lines back in front of anything written since
+// they were taken, again honoring maxBuf.
+func (s *NetworkSink) requeue(batch [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	newer := s.queue
+	s.queue = nil
+	s.queued = 0
+	for _, line := range batch {
+		s.push(line)
+	}
+	for _, line := range newer {
+		s.push(line)
+	}
+}
+
+func (s *NetworkSink) run() {
+	defer close(s.exited)
+
+	var conn net.Conn
+	backoff := netSinkMinBackoff
+
+	for {
+		select {
+		case <-s.wake:
+		case <-s.done:
+			if conn != nil {
+				// Bound the final flush so a stalled collector cannot hang Close.
+				_ = conn.SetWriteDeadline(time.Now().Add(netSinkWriteTimeout))
+				_, _ = writeLines(conn, s.take())
+				_ = conn.Close()
+			}
+			return
+		}
+
+		for {
+			batch := s.take()
+			if len(batch) == 0 {
+				break
+			}
+			if conn == nil {
+				c, err := s.dial(s.network, s.addr)
+				if err != nil {
+					s.requeue(batch)
+					select {
+					case <-time.After(backoff):
+					case <-s.done:
+						return
+					}
+					backoff = min(backoff*2, netSinkMaxBackoff)
+					continue
+				}
+				conn = c
+				backoff = netSinkMinBackoff
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(netSinkWriteTimeout))
+			if n, err := writeLines(conn, batch); err != nil {
+				// The failed line is retried in full on the next connection.
+				s.requeue(batch[n:])
+				_ = conn.Close()
+				conn = nil
+				select {
+				case <-s.done:
+					return
+				default:
+				}
+			}
+		}
+	}
+}
+
+// writeLines writes batch to conn and returns how many lines were fully
+// delivered.
+func writeLines(conn net.Conn, batch [][]byte) (int, error) {
+	for i, line := range batch {
+		if _, err := conn.Write(line); err != nil {
+			return i, err
+		}
+	}
+	return len(batch), nil
+}
@@ -0,0 +1,231 @@
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeDialer hands out the client side of net.Pipe connections and
+// publishes the server side on conns. Dials fail while down is set.
+type pipeDialer struct {
+	mu    sync.Mutex
+	down  bool
+	conns chan net.Conn
+}
+
+func newPipeDialer(down bool) *pipeDialer {
+	return &pipeDialer{down: down, conns: make(chan net.Conn, 4)}
+}
+
+func (d *pipeDialer) setDown(down bool) {
+	d.mu.Lock()
+	d.down = down
+	d.mu.Unlock()
+}
+
+func (d *pipeDialer) dial(network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down {
+		return nil, errors.New("collector unreachable")
+	}
+	client, server := net.Pipe()
+	d.conns <- server
+	return client, nil
+}
+
+func (d *pipeDialer) accept(t *testing.T) net.Conn {
+	t.Helper()
+	select {
+	case c := <-d.conns:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the sink to connect")
+		return nil
+	}
+}
+
+func readLine(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read from sink connection: %v", err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+func TestNetworkSinkTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	sink := NewNetworkSink("tcp", ln.Addr().String(), 0)
+	defer sink.Close()
+
+	l := NewStdLogger(false, false, false, false, false)
+	l.SetOutput(sink)
+	l.Noticef("shipped %d", 1)
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if got := readLine(t, bufio.NewReader(conn)); got != "[INF] shipped 1" {
+		t.Fatalf("unexpected line %q", got)
+	}
+}
+
+func TestNetworkSinkDropOldest(t *testing.T) {
+	d := newPipeDialer(true)
+	sink := newNetworkSink("tcp", "collector", 30, d.dial)
+	defer sink.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := sink.Write([]byte("line-" + string(rune('0'+i)) + "\n")); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+	}
+	// 7 bytes per line, 30 byte buffer: only the last 4 survive
+	if got := sink.Dropped(); got != 6 {
+		t.Fatalf("Dropped=%d, want=6", got)
+	}
+
+	d.setDown(false)
+	r := bufio.NewReader(d.accept(t))
+	for _, want := range []string{"line-6", "line-7", "line-8", "line-9"} {
+		if got := readLine(t, r); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestNetworkSinkReconnect(t *testing.T) {
+	d := newPipeDialer(false)
+	sink := newNetworkSink("tcp", "collector", 0, d.dial)
+	defer sink.Close()
+
+	_, _ = sink.Write([]byte("first\n"))
+	conn := d.accept(t)
+	if got := readLine(t, bufio.NewReader(conn)); got != "first" {
+		t.Fatalf("got %q, want %q", got, "first")
+	}
+
+	// Kill the connection from the collector side; the next write fails
+	// and must be redelivered on a fresh connection.
+	conn.Close()
+
+	_, _ = sink.Write([]byte("second\n"))
+	conn = d.accept(t)
+	defer conn.Close()
+	if got := readLine(t, bufio.NewReader(conn)); got != "second" {
+		t.Fatalf("got %q, want %q", got, "second")
+	}
+}
+
+// A collector that stops reading must not block Close.
+func TestNetworkSinkStalledCollector(t *testing.T) {
+	defer func(d time.Duration) { netSinkWriteTimeout = d }(netSinkWriteTimeout)
+	netSinkWriteTimeout = 50 * time.Millisecond
+
+	d := newPipeDialer(false)
+	sink := newNetworkSink("tcp", "collector", 0, d.dial)
+	_, _ = sink.Write([]byte("never read\n"))
+
+	// accept connections without ever reading from them
+	stop := make(chan struct{})
+	var conns []net.Conn
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case c := <-d.conns:
+				conns = append(conns, c)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	time.Sleep(2 * netSinkWriteTimeout)
+
+	closed := make(chan struct{})
+	go func() {
+		sink.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on a stalled collector")
+	}
+	close(stop)
+	wg.Wait()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func TestNetworkSinkClosed(t *testing.T) {
+	sink := newNetworkSink("tcp", "collector", 0, newPipeDialer(true).dial)
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if _, err := sink.Write([]byte("late\n")); err == nil {
+		t.Fatal("Write after Close should fail")
+	}
+}
+
+// recordConn is a net.Conn that keeps everything written to it.
+type recordConn struct {
+	net.Conn
+	mu   sync.Mutex
+	data []byte
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = append(c.data, p...)
+	return len(p), nil
+}
+
+func (c *recordConn) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return string(c.data)
+}
+
+func (c *recordConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *recordConn) Close() error                     { return nil }
+
+// Fatalf delivers the queued records before the exit function runs.
+func TestNetworkSinkFlushOnFatal(t *testing.T) {
+	conn := &recordConn{}
+	sink := newNetworkSink("tcp", "collector", 0, func(string, string) (net.Conn, error) {
+		time.Sleep(20 * time.Millisecond) // slow enough to lose the race with exit
+		return conn, nil
+	})
+	defer sink.Close()
+
+	l := NewStdLogger(false, false, false, false, false)
+	l.SetOutput(sink)
+	var atExit string
+	l.SetExitFunc(func(int) { atExit = conn.String() })
+	l.Noticef("before")
+	l.Fatalf("fatal")
+
+	if atExit != "[INF] before\n[FTL] fatal\n" {
+		t.Fatalf("delivered at exit %q", atExit)
+	}
+}
@@ -0,0 +1,56 @@
+package logger
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// sampler keeps a stable fraction of records keyed by one field's value.
+type sampler struct {
+	field     string
+	threshold uint64 // keep when hash(value) < threshold
+	all       bool   // fraction >= 1
+}
+
+func newSampler(field string, fraction float64) *sampler {
+	s := &sampler{field: field}
+	switch {
+	case fraction >= 1:
+		s.all = true
+	case fraction > 0:
+		s.threshold = uint64(fraction * math.MaxUint64)
+	}
+	return s
+}
+
+// keep reports whether r should be emitted. Records without the field are
+// always kept.
+func (s *sampler) keep(r *record) bool {
+	if s.all {
+		return true
+	}
+	for i := len(r.fields) - 1; i >= 0; i-- {
+		if r.fields[i].key == s.field {
+			h := fnv.New64a()
+			if v, ok := r.fields[i].value.(string); ok {
+				h.Write([]byte(v))
+			} else {
+				fmt.Fprint(h, r.fields[i].value)
+			}
+			return mix64(h.Sum64()) < s.threshold
+		}
+	}
+	return true
+}
+
+// mix64 is the MurmurHash3 finalizer; FNV alone distributes the high bits
+// of short, similar keys poorly.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
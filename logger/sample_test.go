@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSamplerStablePerKey(t *testing.T) {
+	s := newSampler("req", 0.25)
+
+	kept := 0
+	for i := 0; i < 4000; i++ {
+		r := record{fields: []field{{"req", fmt.Sprintf("id-%d", i)}}}
+		first := s.keep(&r)
+		// the decision for a given value never changes
+		for j := 0; j < 3; j++ {
+			if s.keep(&r) != first {
+				t.Fatalf("sampling decision for id-%d is not stable", i)
+			}
+		}
+		if first {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Fatalf("kept %d of 4000 records, want about 1000", kept)
+	}
+}
+
+func TestSamplerBounds(t *testing.T) {
+	r := record{fields: []field{{"req", 42}}}
+	if !newSampler("req", 1).keep(&r) {
+		t.Fatal("fraction 1 should keep everything")
+	}
+	if newSampler("req", 0).keep(&r) {
+		t.Fatal("fraction 0 should drop records with the field")
+	}
+	if !newSampler("other", 0).keep(&r) {
+		t.Fatal("records without the field should bypass sampling")
+	}
+}
+
+func TestSetSampler(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+
+	l.SetSampler("req", 0)
+	l.Noticef("no field")
+	assertContains(t, buf, "[INF] no field")
+
+	l.SetSampler("", 0)
+	if l.sampler != nil {
+		t.Fatal("empty field should disable sampling")
+	}
+}
@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogHandler routes log/slog records to a Logger.
+type slogHandler struct {
+	l      *Logger
+	prefix string  // accumulated group names, each followed by '.'
+	fields []field // attributes added with WithAttrs
+}
+
+// NewSlogHandler returns a slog.Handler writing to l, so that code using
+// log/slog shares l's output, format and rotation. slog levels map to
+// TRACE (below Debug), DEBUG, INFO, WARN and ERROR. Attributes become
+// key=value fields after the message; attributes inside groups are named
+// group.key.
+func NewSlogHandler(l *Logger) slog.Handler {
+	return &slogHandler{l: l}
+}
+
+// levelFromSlog maps a slog level onto the closest Level.
+func levelFromSlog(level slog.Level) Level {
+	switch {
+	case level < slog.LevelDebug:
+		return TraceLevel
+	case level < slog.LevelInfo:
+		return DebugLevel
+	case level < slog.LevelWarn:
+		return InfoLevel
+	case level < slog.LevelError:
+		return WarnLevel
+	default:
+		return ErrorLevel
+	}
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.l.enabled(levelFromSlog(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make([]field, len(h.fields), len(h.fields)+r.NumAttrs())
+	copy(fields, h.fields)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendSlogAttr(fields, h.prefix, a)
+		return true
+	})
+	h.l.emit(&record{level: levelFromSlog(r.Level), msg: r.Message, fields: fields, pc: r.PC})
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.fields = append([]field(nil), h.fields...)
+	for _, a := range attrs {
+		h2.fields = appendSlogAttr(h2.fields, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+// appendSlogAttr appends a as fields named with prefix, flattening groups.
+// Empty attributes are dropped and groups without a key are inlined, as
+// slog handlers are expected to do.
+func appendSlogAttr(fields []field, prefix string, a slog.Attr) []field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			fields = appendSlogAttr(fields, prefix, ga)
+		}
+		return fields
+	}
+	return append(fields, field{prefix + a.Key, a.Value.Any()})
+}
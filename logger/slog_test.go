@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSlogHandler(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.useTime = false
+	sl := slog.New(NewSlogHandler(l))
+
+	sl.Info("request served", "status", 200, "path", "/index")
+	assertContains(t, buf, "[INF] request served status=200 path=/index")
+
+	buf.Reset()
+	sl.Warn("slow", "took", 1500*time.Millisecond)
+	assertContains(t, buf, "[WRN] slow took=1.5s")
+
+	buf.Reset()
+	sl.Error("failed", slog.Group("req", "id", 7, slog.Group("peer", "ip", "10.0.0.1")))
+	assertContains(t, buf, "[ERR] failed req.id=7 req.peer.ip=10.0.0.1")
+
+	buf.Reset()
+	sl.Debug("debugging")
+	assertContains(t, buf, "[DBG] debugging")
+
+	buf.Reset()
+	sl.Log(context.Background(), slog.LevelDebug-4, "tracing")
+	assertContains(t, buf, "[TRC] tracing")
+}
+
+func TestSlogHandlerWithAttrsAndGroup(t *testing.T) {
+	l, buf := newTestStdLogger(t)
+	l.useTime = false
+
+	sl := slog.New(NewSlogHandler(l)).With("svc", "api").WithGroup("http").With("method", "GET")
+	sl.Info("hit", "code", 404, slog.Group("", "inlined", true))
+	assertContains(t, buf, "[INF] hit svc=api http.method=GET http.code=404 http.inlined=true")
+}
+
+func TestSlogHandlerEnabled(t *testing.T) {
+	l := NewStdLogger(false, false, false, false, false)
+	h := NewSlogHandler(l)
+
+	if h.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("debug should be disabled on an info logger")
+	}
+	if !h.Enabled(context.Background(), slog.LevelInfo) {
+		t.Fatal("info should be enabled")
+	}
+	l.SetTrace(true)
+	if !h.Enabled(context.Background(), slog.LevelDebug-4) {
+		t.Fatal("trace should be enabled after SetTrace(true)")
+	}
+}
@@ -2,6 +2,7 @@ package logger
 
 import (
     "fmt"
+    "io"
     "log"
     "log/syslog"
     "net/url"
@@ -120,3 +121,48 @@ func (l *SysLogger) Close() error {
     return nil
 }
 
+// NewSyslogLogger returns a *Logger that sends records to syslog, local
+// when network is "" or to addr over network ("udp", "tcp", "unix")
+// otherwise. tag defaults to GetSysLoggerTag(). Levels map to severities:
+// INFO to LOG_INFO, WARN to LOG_WARNING, ERROR to LOG_ERR, FATAL to
+// LOG_CRIT and DEBUG/TRACE to LOG_DEBUG. Records carry no timestamp or
+// label of their own, since syslog adds both. A dropped connection is
+// re-established on the next write; SetOutput and SetNonBlocking do not
+// apply to syslog loggers.
+func NewSyslogLogger(network, addr, tag string, debug, trace bool, opts ...LogOption) (*Logger, error) {
+    if tag == "" {
+        tag = GetSysLoggerTag()
+    }
+
+    var writer *syslog.Writer
+    var err error
+    if network == "" {
+        writer, err = syslog.New(syslog.LOG_DAEMON|syslog.LOG_NOTICE, tag)
+    } else {
+        writer, err = syslog.Dial(network, addr, syslog.LOG_DAEMON|syslog.LOG_NOTICE, tag)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+    }
+
+    l := newLogger(log.New(io.Discard, "", 0), false, debug, trace, false, opts...)
+    l.sysw = writer
+    return l, nil
+}
+
+// writeSyslog writes line with the severity matching level. syslog.Writer
+// redials and retries once if the write fails.
+func writeSyslog(w *syslog.Writer, level Level, line string) error {
+    switch level {
+    case TraceLevel, DebugLevel:
+        return w.Debug(line)
+    case WarnLevel:
+        return w.Warning(line)
+    case ErrorLevel:
+        return w.Err(line)
+    case FatalLevel:
+        return w.Crit(line)
+    default:
+        return w.Info(line)
+    }
+}
@@ -1,7 +1,10 @@
 package logger
 
 import (
+	"net"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetSysLoggerTag(t *testing.T) {
@@ -89,3 +92,48 @@ func TestSysLogger_Close(t *testing.T) {
 		t.Errorf("Expected no error on Close, got: %v", err)
 	}
 }
+
+func TestNewSyslogLogger_Severities(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+
+	l, err := NewSyslogLogger("udp", pc.LocalAddr().String(), "ark", true, false)
+	if err != nil {
+		t.Fatalf("NewSyslogLogger error: %v", err)
+	}
+	defer l.Close()
+
+	// LOG_DAEMON is facility 3, so the priority is 24 + severity
+	tests := []struct {
+		log  func(string, ...any)
+		want string
+	}{
+		{l.Noticef, "<30>"}, // LOG_INFO
+		{l.Warnf, "<28>"},   // LOG_WARNING
+		{l.Errorf, "<27>"},  // LOG_ERR
+		{l.Debugf, "<31>"},  // LOG_DEBUG
+	}
+	buf := make([]byte, 1024)
+	for _, tt := range tests {
+		tt.log("hello %d", 1)
+		_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		msg := string(buf[:n])
+		if !strings.HasPrefix(msg, tt.want) || !strings.Contains(msg, " ark[") ||
+			!strings.HasSuffix(strings.TrimSuffix(msg, "\n"), ": hello 1") {
+			t.Fatalf("got %q, want priority %s and bare message", msg, tt.want)
+		}
+	}
+}
+
+func TestNewSyslogLogger_DialError(t *testing.T) {
+	if _, err := NewSyslogLogger("bogus", "127.0.0.1:1", "ark", false, false); err == nil {
+		t.Fatal("expected an error for an unknown network")
+	}
+}
@@ -0,0 +1,75 @@
+package logger
+
+// tailRing retains the most recent formatted lines.
+type tailRing struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newTailRing(n int) *tailRing {
+	return &tailRing{lines: make([]string, n)}
+}
+
+func (t *tailRing) add(line string) {
+	t.lines[t.next] = line
+	t.next++
+	if t.next == len(t.lines) {
+		t.next = 0
+		t.full = true
+	}
+}
+
+// snapshot returns the retained lines, oldest first.
+func (t *tailRing) snapshot() []string {
+	if !t.full {
+		return append([]string(nil), t.lines[:t.next]...)
+	}
+	out := make([]string, 0, len(t.lines))
+	out = append(out, t.lines[t.next:]...)
+	return append(out, t.lines[:t.next]...)
+}
+
+func (t *tailRing) reset() {
+	clear(t.lines)
+	t.next = 0
+	t.full = false
+}
+
+// SetTailSize keeps the last n formatted lines in memory for Tail and
+// DrainTail. n <= 0 disables retention and discards retained lines. A
+// NewMultiLogger or NewLeveledFileLogger logger keeps the records logged
+// through it, rendered with its own format and labels.
+func (l *Logger) SetTailSize(n int) {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	if n <= 0 {
+		l.tail = nil
+		return
+	}
+	l.tail = newTailRing(n)
+}
+
+// Tail returns the retained lines, oldest first, without clearing them.
+func (l *Logger) Tail() []string {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	if l.tail == nil {
+		return nil
+	}
+	return l.tail.snapshot()
+}
+
+// DrainTail returns the retained lines, oldest first, and clears them
+// while holding the write lock, so every line is returned by exactly one
+// call even while other goroutines keep logging.
+func (l *Logger) DrainTail() []string {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	if l.tail == nil {
+		return nil
+	}
+	lines := l.tail.snapshot()
+	l.tail.reset()
+	return lines
+}
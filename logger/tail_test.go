@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTail(t *testing.T) {
+	l, _ := newTestStdLogger(t)
+	if l.Tail() != nil {
+		t.Fatal("Tail should be nil when retention is disabled")
+	}
+
+	l.SetTailSize(3)
+	for i := 0; i < 5; i++ {
+		l.Noticef("line %d", i)
+	}
+
+	tail := l.Tail()
+	if len(tail) != 3 {
+		t.Fatalf("Tail len=%d, want=3", len(tail))
+	}
+	for i, want := range []string{"line 2", "line 3", "line 4"} {
+		if !strings.HasSuffix(tail[i], "[INF] "+want) {
+			t.Fatalf("Tail[%d]=%q, want suffix %q", i, tail[i], want)
+		}
+	}
+	// Tail only peeks
+	if len(l.Tail()) != 3 {
+		t.Fatal("Tail should not clear retained lines")
+	}
+}
+
+// Loggers whose destinations write the records still keep a tail, and
+// forward the output settings to the destinations.
+func TestTailMultiLogger(t *testing.T) {
+	a, _ := newTestStdLogger(t)
+	b, _ := newTestStdLogger(t)
+	multi := NewMultiLogger(a, b)
+
+	dir := t.TempDir()
+	leveled, err := NewLeveledFileLogger(nil, filepath.Join(dir, "all.log"), false, false, false, false)
+	if err != nil {
+		t.Fatalf("NewLeveledFileLogger error: %v", err)
+	}
+	defer leveled.Close()
+
+	for name, l := range map[string]*Logger{"multi": multi, "leveled": leveled} {
+		l.SetNonBlocking(8)
+		for _, c := range l.children {
+			if c.async == nil {
+				t.Fatalf("%s: SetNonBlocking not forwarded", name)
+			}
+		}
+		l.SetNonBlocking(0)
+
+		l.SetTailSize(2)
+		var out bytes.Buffer
+		l.SetOutput(&out)
+		l.Noticef("one")
+		l.Warnf("two")
+
+		if tail := l.Tail(); len(tail) != 2 || tail[0] != "[INF] one" || tail[1] != "[WRN] two" {
+			t.Fatalf("%s: Tail=%q", name, tail)
+		}
+		if n := strings.Count(out.String(), "[WRN] two\n"); n != len(l.children) {
+			t.Fatalf("%s: SetOutput not forwarded: %q", name, out.String())
+		}
+	}
+}
+
+func TestDrainTail(t *testing.T) {
+	l, _ := newTestStdLogger(t)
+	l.SetTailSize(4)
+
+	l.Noticef("a")
+	l.Noticef("b")
+	lines := l.DrainTail()
+	if len(lines) != 2 {
+		t.Fatalf("DrainTail len=%d, want=2", len(lines))
+	}
+	if got := l.DrainTail(); len(got) != 0 {
+		t.Fatalf("second DrainTail should be empty, got %q", got)
+	}
+
+	l.Noticef("c")
+	if got := l.Tail(); len(got) != 1 || !strings.HasSuffix(got[0], "[INF] c") {
+		t.Fatalf("Tail after drain=%q", got)
+	}
+}
+
+func TestDrainTailConcurrent(t *testing.T) {
+	l, _ := newTestStdLogger(t)
+	l.SetTailSize(10000)
+
+	const writers, perWriter = 4, 500
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				l.Noticef("w%d-%d", w, i)
+			}
+		}(w)
+	}
+
+	seen := make(map[string]bool)
+	collect := func() {
+		for _, line := range l.DrainTail() {
+			if seen[line] {
+				t.Errorf("line returned twice: %q", line)
+			}
+			seen[line] = true
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			collect()
+			if len(seen) != writers*perWriter {
+				t.Fatalf("drained %d lines, want %d", len(seen), writers*perWriter)
+			}
+			return
+		default:
+			collect()
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package logger
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// isTerminal reports whether fd refers to a terminal: only a terminal
+// answers the termios request, unlike other character devices such as
+// /dev/null.
+func isTerminal(fd uintptr) bool {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlReadTermios, uintptr(unsafe.Pointer(&t)))
+	return errno == 0
+}
@@ -0,0 +1,8 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package logger
+
+// isTerminal reports false: terminals are not detected on this platform.
+func isTerminal(fd uintptr) bool {
+	return false
+}